@@ -2,38 +2,132 @@ package cmd
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
+	"io/fs"
 	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
 	"github.com/andrewheberle/jwks-to-pem/pkg/jwks"
+	"github.com/andrewheberle/jwks-to-pem/pkg/metrics"
+	"github.com/andrewheberle/jwks-to-pem/pkg/notify"
 	"github.com/andrewheberle/jwks-to-pem/pkg/reload"
 	"github.com/andrewheberle/simplecommand"
 	"github.com/bep/simplecobra"
 )
 
 type rootCommand struct {
-	jwksUrl       string
-	outputDir     string
-	outputPattern string
-	timeout       time.Duration
-	debug         bool
-	reloadUrl     string
-	reloadPayload string
-	reloadMethod  string
-	reloadPid     int
-	reloadPidfile string
-	reloadSignal  signal
-	reloadSocket  string
+	jwksUrls             []string
+	urlMode              string
+	outputDir            string
+	outputPattern        string
+	outputPatternFile    string
+	outputPatternRSA     string
+	outputPatternEC      string
+	outputPatternOKP     string
+	splitByAlg           bool
+	splitAlgs            []string
+	format               string
+	pemType              string
+	envfileName          string
+	singleFile           string
+	algMap               map[string]string
+	slots                int
+	prune                bool
+	pruneExclude         []string
+	allOrNothing         bool
+	strict               bool
+	dryRun               bool
+	mkdir                bool
+	manifest             string
+	kidHash              bool
+	versionedDir         bool
+	keepVersions         int
+	bundlePerIssuer      bool
+	timeout              time.Duration
+	maxBodySize          int64
+	tokenFile            string
+	minTLSVersion        string
+	httpMaxIdleConns     int
+	httpIdleConnTimeout  time.Duration
+	retries              int
+	retryInterval        time.Duration
+	lenientParse         bool
+	publicOnly           bool
+	maxRedirects         int
+	redirectCredentials  bool
+	headerValues         []string
+	headers              http.Header
+	tlsClientCert        string
+	tlsClientKey         string
+	tlsCA                string
+	use                  string
+	logTLS               bool
+	verifyCmd            string
+	matchDirOwner        bool
+	semanticCompare      bool
+	fingerprintComment   bool
+	preferX5C            bool
+	verifyX5C            bool
+	followSymlinks       bool
+	fileModeValue        string
+	fileMode             fs.FileMode
+	filteredJWKS         string
+	errorFile            string
+	textfileOut          string
+	readyFile            string
+	warnExpiryWithin     time.Duration
+	failOnNearExpiry     bool
+	notifyUrl            string
+	debug                bool
+	configFile           string
+	logFormat            string
+	logLevel             string
+	reloadUrl            string
+	reloadPayload        string
+	reloadMethod         string
+	reloadHTTP1          bool
+	reloadHTTP2          bool
+	reloadPid            int
+	reloadPidfile        string
+	reloadPidfileTimeout time.Duration
+	reloadProcessName    string
+	reloadPgid           int
+	reloadAll            bool
+	reloadSignal         signal
+	reloadSocket         string
+	reloadSocketTimeout  time.Duration
+	reloadSocketAck      string
+	reloadTCP            string
+	reloadK8sDeployment  string
+	reloadK8sStatefulSet string
+	reloadExec           string
+	reloadSystemd        bool
+	reloadExpectStatus   []int
+	reloadContentType    string
+	reloadTimeout        time.Duration
+	reloadHeaderValues   []string
 
 	logger *slog.Logger
 
 	reloader reload.Reloader
+	notifier *notify.Notifier
+
+	// metrics are recorded when served by a long running sub-command
+	metrics *metrics.Metrics
+
+	// validators are set when polling so unchanged keys are not fetched
+	validators *jwks.Validators
 
 	*simplecommand.Command
 }
@@ -46,60 +140,307 @@ func (sig *signal) Type() string {
 	return "signal"
 }
 
+// signalnumber converts a number such as "10" into a signal if it is a
+// known signal on this platform
+func signalnumber(s string) (syscall.Signal, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, err
+	}
+
+	// unknown signals are named "signal N"
+	sig := syscall.Signal(n)
+	if n <= 0 || strings.HasPrefix(sig.String(), "signal ") {
+		return 0, fmt.Errorf("unknown signal number: %d", n)
+	}
+
+	return sig, nil
+}
+
 func (c *rootCommand) Init(cd *simplecobra.Commandeer) error {
 	if err := c.Command.Init(cd); err != nil {
 		return err
 	}
 
 	// set default for reload signal
-	c.reloadSignal = signal{syscall.SIGHUP}
+	c.reloadSignal = signal{defaultSignal}
 
 	// command line flags
 	cmd := cd.CobraCommand
-	cmd.PersistentFlags().StringVarP(&c.jwksUrl, "url", "u", "", "URL for JSON Web Key Set (JWKS)")
+	cmd.PersistentFlags().StringArrayVarP(&c.jwksUrls, "url", "u", nil, "URL for JSON Web Key Set (JWKS), may be repeated")
+	cmd.PersistentFlags().StringVar(&c.urlMode, "url-mode", "failover", "How multiple URLs are used (failover or merge)")
 	cmd.PersistentFlags().StringVarP(&c.outputDir, "out", "o", "", "Output directory")
 	cmd.PersistentFlags().StringVarP(&c.outputPattern, "pattern", "p", "{{ .KeyID }}.pem", "Output pattern")
+	cmd.PersistentFlags().StringVar(&c.outputPatternFile, "pattern-file", "", "File containing the output pattern")
+	cmd.PersistentFlags().StringVar(&c.outputPatternRSA, "pattern-rsa", "", "Output pattern for RSA keys (overrides --pattern)")
+	cmd.PersistentFlags().StringVar(&c.outputPatternEC, "pattern-ec", "", "Output pattern for EC keys (overrides --pattern)")
+	cmd.PersistentFlags().StringVar(&c.outputPatternOKP, "pattern-okp", "", "Output pattern for OKP keys (overrides --pattern)")
+	cmd.PersistentFlags().BoolVar(&c.splitByAlg, "split-by-alg", false, "Write keys to a sub-directory named after their algorithm")
+	cmd.PersistentFlags().StringSliceVar(&c.splitAlgs, "split-alg", nil, "Only split these algorithms into sub-directories (implies --split-by-alg)")
+	cmd.PersistentFlags().StringVar(&c.format, "format", "pem", "Output format (pem, der, jwk, ssh or envfile)")
+	cmd.PersistentFlags().StringVar(&c.pemType, "pem-type", jwks.PEMTypePKIX, "Encoding of keys in PEM format (pkix or pkcs1, which only supports RSA keys)")
+	cmd.PersistentFlags().StringVar(&c.envfileName, "envfile-name", "keys.env", "Name of the file written to the output directory for the envfile format")
+	cmd.PersistentFlags().StringVar(&c.singleFile, "single-file", "", "Write all keys to a single bundle at this path")
+	cmd.PersistentFlags().BoolVar(&c.kidHash, "kid-hash", false, "Use a short SHA-256 hash of the key ID as {{ .KeyID }} in file names")
+	cmd.PersistentFlags().IntVar(&c.slots, "slots", 0, "Only write the newest N keys, with {{ .Index }} as the slot number")
+	cmd.PersistentFlags().BoolVar(&c.allOrNothing, "all-or-nothing-validate", false, "Write no keys at all if any key in the JWKS cannot be converted")
+	cmd.PersistentFlags().BoolVar(&c.dryRun, "dry-run", false, "Log which keys would be written or pruned without writing any files or triggering a reload")
+	cmd.PersistentFlags().BoolVar(&c.mkdir, "mkdir", false, "Create the output directory and any subdirectories from the pattern if they do not exist")
+	cmd.PersistentFlags().BoolVar(&c.strict, "strict", false, "Fail the run if the JWKS contains keys of an unsupported type rather than skipping them")
+	cmd.PersistentFlags().BoolVar(&c.prune, "prune", false, "Remove files matching the pattern for keys no longer in the JWKS")
+	cmd.PersistentFlags().StringArrayVar(&c.pruneExclude, "prune-exclude", nil, "Key ID or glob of files to never prune, may be repeated")
+	cmd.PersistentFlags().BoolVar(&c.bundlePerIssuer, "bundle-per-issuer", false, "Write the keys from each URL to a bundle named after its host in the output directory")
+	cmd.PersistentFlags().BoolVar(&c.versionedDir, "versioned-dir", false, "Write keys to a new versioned directory and point a \"current\" symlink at it")
+	cmd.PersistentFlags().IntVar(&c.keepVersions, "keep-versions", 3, "Number of versioned directories to keep")
+	cmd.PersistentFlags().StringToStringVar(&c.algMap, "alg-map", nil, "Rename algorithms exposed to templates, for example ES256=ecdsa-sha256")
 	cmd.PersistentFlags().DurationVar(&c.timeout, "timeout", time.Second*5, "Timeout to retrieve JWKS")
+	cmd.PersistentFlags().Int64Var(&c.maxBodySize, "max-body-size", jwks.DefaultMaxBodySize, "Maximum size in bytes of the JWKS response")
+	cmd.PersistentFlags().IntVar(&c.maxRedirects, "max-redirects", jwks.DefaultMaxRedirects, "Maximum number of redirects to follow when fetching the JWKS")
+	cmd.PersistentFlags().BoolVar(&c.redirectCredentials, "redirect-credentials", false, "Send the Authorization and custom headers again after a redirect to a different host")
+	cmd.PersistentFlags().StringVar(&c.minTLSVersion, "min-tls-version", "1.2", "Minimum TLS version accepted from the JWKS server (1.2 or 1.3)")
+	cmd.PersistentFlags().StringVar(&c.tlsClientCert, "tls-client-cert", "", "Client certificate to present to the JWKS server for mutual TLS")
+	cmd.PersistentFlags().StringVar(&c.tlsClientKey, "tls-client-key", "", "Private key for --tls-client-cert")
+	cmd.PersistentFlags().StringVar(&c.tlsCA, "tls-ca", "", "CA certificates to verify the JWKS server rather than the system roots")
+	cmd.PersistentFlags().IntVar(&c.httpMaxIdleConns, "http-max-idle-conns", http.DefaultTransport.(*http.Transport).MaxIdleConns, "Maximum idle keep-alive connections kept for fetching the JWKS")
+	cmd.PersistentFlags().DurationVar(&c.httpIdleConnTimeout, "http-idle-conn-timeout", http.DefaultTransport.(*http.Transport).IdleConnTimeout, "How long idle keep-alive connections are kept for fetching the JWKS")
+	cmd.PersistentFlags().StringArrayVar(&c.headerValues, "header", nil, "Header to send when fetching the JWKS as \"Key: Value\", may be repeated")
+	cmd.PersistentFlags().StringVar(&c.tokenFile, "token-file", "", "File containing a bearer token to send when fetching the JWKS")
+	cmd.PersistentFlags().IntVar(&c.retries, "retries", 3, "Number of times to retry fetching the JWKS")
+	cmd.PersistentFlags().DurationVar(&c.retryInterval, "retry-interval", time.Second, "Interval between fetch retries unless set by Retry-After")
+	cmd.PersistentFlags().BoolVar(&c.lenientParse, "lenient-parse", false, "Accept a bare JSON array of keys from non-standard endpoints")
+	cmd.PersistentFlags().StringVar(&c.use, "use", "", "Only write keys intended for this use (sig or enc)")
+	cmd.PersistentFlags().BoolVar(&c.publicOnly, "public-only", true, "Reject a JWKS that contains private key material")
+	cmd.PersistentFlags().StringVar(&c.notifyUrl, "notify-url", "", "URL to POST a JSON summary to when keys change")
+	cmd.PersistentFlags().StringVar(&c.errorFile, "error-file", "", "Write a JSON list of keys that failed to this path")
+	cmd.PersistentFlags().DurationVar(&c.warnExpiryWithin, "warn-expiry-within", 0, "Warn about keys with an x5c certificate that expires within this duration")
+	cmd.PersistentFlags().BoolVar(&c.failOnNearExpiry, "fail-on-near-expiry", false, "Fail the run if any key is about to expire (requires --warn-expiry-within)")
+	cmd.PersistentFlags().StringVar(&c.readyFile, "ready-file", "", "File updated after each fully successful run and removed after a failed one")
+	cmd.PersistentFlags().StringVar(&c.textfileOut, "textfile-out", "", "Write key metrics in Prometheus textfile format to this path")
+	cmd.PersistentFlags().StringVar(&c.manifest, "manifest", "", "Write a JSON manifest of the key files to this path, or \"-\" for stdout")
+	cmd.PersistentFlags().StringVar(&c.filteredJWKS, "write-filtered-jwks", "", "Write a JWKS containing only the supported keys to this path")
+	cmd.PersistentFlags().BoolVar(&c.semanticCompare, "semantic-compare", false, "Compare existing keys by their public key rather than byte for byte")
+	cmd.PersistentFlags().BoolVar(&c.fingerprintComment, "fingerprint-comment", false, "Add a comment with the SHA-256 fingerprint of each key")
+	cmd.PersistentFlags().BoolVar(&c.preferX5C, "prefer-x5c", false, "Write the x5c certificate chain of a key, when it has one, rather than its public key")
+	cmd.PersistentFlags().BoolVar(&c.verifyX5C, "verify-x5c", false, "Refuse to write a key whose x5c certificate does not match the key")
+	cmd.PersistentFlags().BoolVar(&c.followSymlinks, "follow-symlinks", false, "Write through symlinks rather than replacing them")
+	cmd.PersistentFlags().StringVar(&c.fileModeValue, "file-mode", "0644", "Permissions of written files in octal")
+	cmd.PersistentFlags().BoolVar(&c.matchDirOwner, "match-dir-owner", false, "Set the owner of written keys to match the output directory")
+	cmd.PersistentFlags().BoolVar(&c.logTLS, "log-tls", false, "Log the certificate presented by the JWKS server")
+	cmd.PersistentFlags().StringVar(&c.verifyCmd, "verify-cmd", "", "Command to verify each key before it is written (path is appended)")
 	cmd.PersistentFlags().StringVar(&c.reloadSocket, "reload.socket", "", "Socket to use for reloads")
+	cmd.PersistentFlags().DurationVar(&c.reloadSocketTimeout, "reload.socket-timeout", time.Second*5, "Timeout for socket based reloads")
+	cmd.PersistentFlags().StringVar(&c.reloadSocketAck, "reload.socket-ack", "", "Expected start of the response line from socket based reloads")
+	cmd.PersistentFlags().StringVar(&c.reloadTCP, "reload.tcp", "", "TCP address (host:port) to use for reloads")
 	cmd.PersistentFlags().StringVar(&c.reloadUrl, "reload.url", "", "URL to use for reloads")
 	cmd.PersistentFlags().StringVar(&c.reloadPayload, "reload.payload", "", "Payload for URL/socket based reloads")
 	cmd.PersistentFlags().IntVar(&c.reloadPid, "reload.pid", 0, "Process ID to signal for reloads")
 	cmd.PersistentFlags().StringVar(&c.reloadPidfile, "reload.pidfile", "", "File to look up process ID to signal for reloads")
+	cmd.PersistentFlags().DurationVar(&c.reloadPidfileTimeout, "reload.pidfile-timeout", time.Second, "How long to retry reading a pidfile that does not contain a running process")
+	cmd.PersistentFlags().IntVar(&c.reloadPgid, "reload.pgid", 0, "Process group ID to signal for reloads, or -1 for our own process group (not supported on windows)")
+	cmd.PersistentFlags().StringVar(&c.reloadProcessName, "reload.process-name", "", "Name of the process to signal for reloads (linux only)")
+	cmd.PersistentFlags().BoolVar(&c.reloadAll, "reload.all", false, "Signal all processes matching --reload.process-name rather than failing when more than one matches")
 	cmd.PersistentFlags().Var(&c.reloadSignal, "reload.signal", "Process ID to signal for reloads")
+	cmd.PersistentFlags().StringVar(&c.reloadK8sDeployment, "reload.k8s-deployment", "", "Kubernetes deployment as namespace/name to restart for reloads")
+	cmd.PersistentFlags().StringVar(&c.reloadK8sStatefulSet, "reload.k8s-statefulset", "", "Kubernetes statefulset as namespace/name to restart for reloads")
+	cmd.PersistentFlags().BoolVar(&c.reloadSystemd, "reload.systemd", false, "Notify systemd of a reload over $NOTIFY_SOCKET (linux only)")
+	cmd.PersistentFlags().StringVar(&c.reloadExec, "reload.exec", "", "Command to run for reloads, such as \"nginx -s reload\"")
 	cmd.PersistentFlags().StringVar(&c.reloadMethod, "reload.method", http.MethodPost, "Method to use for reload URL")
+	cmd.PersistentFlags().IntSliceVar(&c.reloadExpectStatus, "reload.expect-status", nil, "Status codes from the reload URL treated as success (default any 2xx)")
+	cmd.PersistentFlags().StringVar(&c.reloadContentType, "reload.content-type", "", "Content-Type header sent with the payload to the reload URL")
+	cmd.PersistentFlags().DurationVar(&c.reloadTimeout, "reload.timeout", time.Second*10, "Timeout for URL based reloads")
+	cmd.PersistentFlags().StringArrayVar(&c.reloadHeaderValues, "reload.header", nil, "Header to send to the reload URL as \"Key: Value\", may be repeated")
+	cmd.PersistentFlags().BoolVar(&c.reloadHTTP1, "reload.http1", false, "Force HTTP/1.1 for reload URL")
+	cmd.PersistentFlags().BoolVar(&c.reloadHTTP2, "reload.http2", false, "Force HTTP/2 for reload URL")
+	cmd.PersistentFlags().StringVar(&c.configFile, "config", "", "Configuration file (YAML, TOML or JSON) to load options from")
 	cmd.PersistentFlags().BoolVar(&c.debug, "debug", false, "Enable debug logging")
+	cmd.PersistentFlags().MarkDeprecated("debug", "use --log-level debug instead")
+	cmd.PersistentFlags().StringVar(&c.logFormat, "log-format", "text", "Log format (text or json)")
+	cmd.PersistentFlags().StringVar(&c.logLevel, "log-level", "info", "Log level (debug, info, warn or error)")
 
 	// require a url
 	cmd.MarkPersistentFlagRequired("url")
 
 	// dont allow different reload options together
-	cmd.MarkFlagsMutuallyExclusive("reload.url", "reload.pid", "reload.pidfile", "reload.socket")
+	cmd.MarkFlagsMutuallyExclusive("reload.url", "reload.pid", "reload.pidfile", "reload.pgid", "reload.process-name", "reload.socket", "reload.tcp", "reload.k8s-deployment", "reload.k8s-statefulset", "reload.exec", "reload.systemd")
+
+	// the pattern may be provided inline or from a file
+	cmd.MarkFlagsMutuallyExclusive("pattern", "pattern-file")
+
+	// a bundle is written to one path rather than named by a pattern
+	cmd.MarkFlagsMutuallyExclusive("single-file", "pattern", "pattern-file")
+
+	// versioned directories replace the whole output directory
+	cmd.MarkFlagsMutuallyExclusive("versioned-dir", "single-file", "bundle-per-issuer")
+
+	// a dry run is only supported when writing a file per key
+	cmd.MarkFlagsMutuallyExclusive("dry-run", "versioned-dir", "single-file", "bundle-per-issuer")
+
+	// a manifest lists the file of each key
+	cmd.MarkFlagsMutuallyExclusive("manifest", "single-file", "bundle-per-issuer")
+
+	// only one protocol may be forced
+	cmd.MarkFlagsMutuallyExclusive("reload.http1", "reload.http2")
 
 	// a payload makes no sense for pid/pidfile based reloads
 	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.pid")
 	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.pidfile")
+	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.process-name")
+	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.pgid")
+	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.k8s-deployment")
+	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.k8s-statefulset")
+	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.exec")
+	cmd.MarkFlagsMutuallyExclusive("reload.payload", "reload.systemd")
 
 	// socket based reloads required a payload
 	cmd.MarkFlagsRequiredTogether("reload.socket", "reload.payload")
+	cmd.MarkFlagsRequiredTogether("reload.tcp", "reload.payload")
 
 	return nil
 }
 
 func (c *rootCommand) PreRun(this, runner *simplecobra.Commandeer) error {
+	// options are loaded from the config file, then the environment and
+	// then the command line, with later sources taking precedence
+	c.Config = c.configFile
 	if err := c.Command.PreRun(this, runner); err != nil {
 		return err
 	}
 
-	// set up logger
-	logLevel := new(slog.LevelVar)
-	c.logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+	// set up logger, with --debug kept as an alias for the debug level
+	level := c.logLevel
 	if c.debug {
-		logLevel.Set(slog.LevelDebug)
+		level = "debug"
+	}
+	logger, err := newlogger(os.Stderr, c.logFormat, level)
+	if err != nil {
+		return err
+	}
+	c.logger = logger
+
+	// use our logger for any package level logging
+	slog.SetDefault(c.logger)
+
+	// load pattern from file
+	if c.outputPatternFile != "" {
+		b, err := os.ReadFile(c.outputPatternFile)
+		if err != nil {
+			return fmt.Errorf("problem reading pattern file: %w", err)
+		}
+
+		c.outputPattern = strings.TrimRight(string(b), "\r\n")
+	}
+
+	// parse provided patterns
+	for name, pattern := range map[string]string{
+		"pattern":     c.outputPattern,
+		"pattern-rsa": c.outputPatternRSA,
+		"pattern-ec":  c.outputPatternEC,
+		"pattern-okp": c.outputPatternOKP,
+	} {
+		if _, err := template.New(name).Parse(pattern); err != nil {
+			return fmt.Errorf("problem parsing %s: %w", name, err)
+		}
+	}
+
+	// check url mode
+	switch c.urlMode {
+	case "failover", "merge":
+	default:
+		return fmt.Errorf("unsupported url mode: %s", c.urlMode)
+	}
+
+	// check output format
+	switch c.format {
+	case jwks.FormatPEM, jwks.FormatDER, jwks.FormatJWK, jwks.FormatSSH, "envfile":
+	default:
+		return fmt.Errorf("unsupported format: %s", c.format)
+	}
+
+	// check PEM encoding
+	switch c.pemType {
+	case jwks.PEMTypePKIX:
+	case jwks.PEMTypePKCS1:
+		if c.format != jwks.FormatPEM {
+			return fmt.Errorf("--pem-type pkcs1 only supports the pem format")
+		}
+	default:
+		return fmt.Errorf("unsupported PEM type: %s", c.pemType)
+	}
+
+	// check key use
+	switch c.use {
+	case "", "sig", "enc":
+	default:
+		return fmt.Errorf("unsupported key use: %s", c.use)
+	}
+
+	// client certificates need both halves
+	if (c.tlsClientCert == "") != (c.tlsClientKey == "") {
+		return fmt.Errorf("--tls-client-cert and --tls-client-key must be used together")
+	}
+
+	// check request headers
+	h, err := parseheaders(c.headerValues)
+	if err != nil {
+		return err
+	}
+	c.headers = h
+
+	// check minimum tls version
+	if _, err := tlsversion(c.minTLSVersion); err != nil {
+		return err
 	}
 
-	// parse provided pattern
-	if _, err := template.New("pattern").Parse(c.outputPattern); err != nil {
-		return fmt.Errorf("problem parsing pattern: %w", err)
+	// check file mode
+	mode, err := filemode(c.fileModeValue)
+	if err != nil {
+		return err
+	}
+	c.fileMode = mode
+
+	// a dry run is only supported when writing a file per key
+	if c.dryRun && c.format == "envfile" {
+		return fmt.Errorf("--dry-run does not support the envfile format")
+	}
+
+	// as is a manifest
+	if c.manifest != "" && (c.format == "envfile" || c.outputDir == "") {
+		return fmt.Errorf("--manifest requires --out and does not support the envfile format")
+	}
+
+	// bundles are always PEM encoded
+	if c.bundlePerIssuer && c.format != "pem" {
+		return fmt.Errorf("--bundle-per-issuer only supports the pem format")
+	}
+
+	// these describe a single JWKS so cannot be written for each issuer
+	if c.bundlePerIssuer && (c.errorFile != "" || c.textfileOut != "" || c.filteredJWKS != "") {
+		return fmt.Errorf("--bundle-per-issuer cannot be combined with --error-file, --textfile-out or --write-filtered-jwks")
+	}
+
+	// bundles need a text format with comments to mark each key
+	if c.singleFile != "" && c.format != jwks.FormatPEM && c.format != jwks.FormatSSH {
+		return fmt.Errorf("--single-file only supports the pem and ssh formats")
+	}
+
+	// certificates are only written as PEM
+	if c.preferX5C && c.format != jwks.FormatPEM {
+		return fmt.Errorf("--prefer-x5c only supports the pem format")
+	}
+
+	// versioned directories need somewhere to live
+	if c.versionedDir && c.outputDir == "" {
+		return fmt.Errorf("--versioned-dir requires an output directory")
+	}
+
+	// set up change notifications
+	if c.notifyUrl != "" {
+		notifier, err := notify.NewNotifier(c.notifyUrl, c.timeout)
+		if err != nil {
+			return err
+		}
+
+		c.notifier = notifier
 	}
 
 	// set up reloader
@@ -115,7 +456,7 @@ func (c *rootCommand) PreRun(this, runner *simplecobra.Commandeer) error {
 
 		c.reloader = reloader
 	} else if c.reloadPidfile != "" {
-		reloader, err := reload.NewProcessReloaderFromPidfile(c.reloadPidfile, c.reloadSignal.v)
+		reloader, err := reload.NewProcessReloaderFromPidfile(c.reloadPidfile, c.reloadSignal.v, c.reloadPidfileTimeout)
 		if err != nil {
 			return err
 		}
@@ -124,6 +465,20 @@ func (c *rootCommand) PreRun(this, runner *simplecobra.Commandeer) error {
 			c.logger.Warn("the pid selected for reload seems to be ours", "pid", reloader.Pid())
 		}
 
+		c.reloader = reloader
+	} else if c.reloadPgid != 0 {
+		reloader, err := reload.NewProcessGroupReloader(c.reloadPgid, c.reloadSignal.v)
+		if err != nil {
+			return err
+		}
+
+		c.reloader = reloader
+	} else if c.reloadProcessName != "" {
+		reloader, err := reload.NewProcessReloaderFromName(c.reloadProcessName, c.reloadSignal.v, c.reloadAll)
+		if err != nil {
+			return err
+		}
+
 		c.reloader = reloader
 	} else if c.reloadUrl != "" {
 		var payload []byte
@@ -133,16 +488,81 @@ func (c *rootCommand) PreRun(this, runner *simplecobra.Commandeer) error {
 			payload = []byte(c.reloadPayload)
 		}
 
+		// check request headers
+		headers, err := parseheaders(c.reloadHeaderValues)
+		if err != nil {
+			return err
+		}
+
+		opts := []reload.HTTPReloaderOption{
+			reload.WithExpectStatus(c.reloadExpectStatus...),
+			reload.WithContentType(c.reloadContentType),
+			reload.WithHTTPTimeout(c.reloadTimeout),
+			reload.WithHTTPHeaders(headers),
+		}
+
+		// force protocol if requested
+		if c.reloadHTTP1 {
+			opts = append(opts, reload.WithHTTP1())
+		}
+		if c.reloadHTTP2 {
+			opts = append(opts, reload.WithHTTP2())
+		}
+
 		// set up reloader
-		reloader, err := reload.NewHTTPReloader(c.reloadUrl, c.reloadMethod, payload)
+		reloader, err := reload.NewHTTPReloader(c.reloadUrl, c.reloadMethod, payload, opts...)
 		if err != nil {
 			return err
 		}
 
 		c.reloader = reloader
-	} else if c.reloadSocket != "" {
-		// set up unix socket based reloader
-		reloader, err := reload.NewUnixSocketReloader(c.reloadSocket, []byte(c.reloadPayload))
+	} else if c.reloadSocket != "" || c.reloadTCP != "" {
+		// set up unix or tcp socket based reloader
+		opts := []reload.SocketReloaderOption{
+			reload.WithSocketTimeout(c.reloadSocketTimeout),
+		}
+		if c.reloadSocketAck != "" {
+			opts = append(opts, reload.WithSocketAck(c.reloadSocketAck))
+		}
+
+		var reloader reload.Reloader
+		var err error
+		if c.reloadTCP != "" {
+			reloader, err = reload.NewTCPReloader(c.reloadTCP, []byte(c.reloadPayload), opts...)
+		} else {
+			reloader, err = reload.NewUnixSocketReloader(c.reloadSocket, []byte(c.reloadPayload), opts...)
+		}
+		if err != nil {
+			return err
+		}
+
+		c.reloader = reloader
+	} else if c.reloadK8sDeployment != "" {
+		// set up kubernetes rollout based reloader
+		reloader, err := reload.NewK8sRolloutReloader("deployment", c.reloadK8sDeployment)
+		if err != nil {
+			return err
+		}
+
+		c.reloader = reloader
+	} else if c.reloadK8sStatefulSet != "" {
+		reloader, err := reload.NewK8sRolloutReloader("statefulset", c.reloadK8sStatefulSet)
+		if err != nil {
+			return err
+		}
+
+		c.reloader = reloader
+	} else if c.reloadExec != "" {
+		// set up command based reloader
+		reloader, err := reload.NewExecReloader(c.reloadExec)
+		if err != nil {
+			return err
+		}
+
+		c.reloader = reloader
+	} else if c.reloadSystemd {
+		// set up systemd notification based reloader
+		reloader, err := reload.NewSystemdReloader()
 		if err != nil {
 			return err
 		}
@@ -150,15 +570,69 @@ func (c *rootCommand) PreRun(this, runner *simplecobra.Commandeer) error {
 		c.reloader = reloader
 	}
 
+	if c.reloader != nil {
+		c.logger.Info("reload configured", "target", c.reloader.Info())
+	}
+
 	return nil
 }
 
 func (c *rootCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	err := c.run(ctx)
+
+	// signal the outcome of the whole run, which a dry run does not change
+	if c.readyFile != "" && !c.dryRun {
+		if err := c.ready(err == nil); err != nil {
+			c.logger.Error("could not update ready file", "path", c.readyFile, "error", err)
+		}
+	}
+
+	return err
+}
+
+// ready records the time of a successful run in the ready file, or
+// removes it if the run failed
+func (c *rootCommand) ready(success bool) error {
+	if !success {
+		if err := os.Remove(c.readyFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+
+		return nil
+	}
+
+	return os.WriteFile(c.readyFile, []byte(time.Now().UTC().Format(time.RFC3339)+"\n"), 0644)
+}
+
+func (c *rootCommand) run(ctx context.Context) error {
+	err := c.process(ctx)
+
+	// forget the validators of a failed run so the next fetch is not
+	// skipped as unmodified while the keys on disk are stale or missing
+	if err != nil && c.validators != nil {
+		*c.validators = jwks.Validators{}
+	}
+
+	return err
+}
+
+// process fetches the JWKS, writes its keys and triggers a reload
+func (c *rootCommand) process(ctx context.Context) error {
 	// some status
-	c.logger.Info("starting fetch process", "url", c.jwksUrl)
+	c.logger.Info("starting fetch process", "url", c.jwksUrls)
+
+	// keep the keys of each issuer separate
+	if c.bundlePerIssuer {
+		return c.runPerIssuer(ctx)
+	}
 
 	// fetch JWKS
-	j, err := jwks.GetJWKS(c.jwksUrl, c.timeout)
+	j, err := c.fetch(ctx)
+	if errors.Is(err, jwks.ErrNotModified) {
+		c.logger.Info("JWKS not modified since last fetch")
+
+		return nil
+	}
 	if err != nil {
 		return fmt.Errorf("problem fetching JWKS: %w", err)
 	}
@@ -166,8 +640,42 @@ func (c *rootCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args
 	// did we finish
 	c.logger.Debug("GetJWKS finished")
 
-	// write keys based on pattern
-	changed, err := j.WriteKeys(c.outputPattern, c.outputDir)
+	// look for keys that are about to expire
+	expiryErr := c.checkexpiry(j)
+
+	// write keys in the chosen format
+	changed, err := c.write(ctx, j, c.writeOptions())
+
+	// summarise how each key was handled
+	counts := j.WriteCounts()
+	c.logger.Info("keys processed", "written", counts.Written, "unchanged", counts.Unchanged, "skipped", counts.Skipped, "errored", counts.Errored)
+
+	// count the keys that are now on disk, as selected by --use and --slots
+	if c.metrics != nil {
+		c.metrics.SetKeys(counts.Written + counts.Unchanged)
+	}
+
+	// record any failed keys
+	if c.errorFile != "" && !c.dryRun {
+		if err := j.WriteErrorFile(c.errorFile, err); err != nil {
+			c.logger.Error("could not write error file", "path", c.errorFile, "error", err)
+		}
+	}
+
+	// record the file of each key
+	if c.manifest != "" && !c.dryRun {
+		if err := j.WriteManifest(os.Stdout, c.manifest); err != nil {
+			c.logger.Error("could not write manifest", "path", c.manifest, "error", err)
+		}
+	}
+
+	// export key metrics for node_exporter
+	if c.textfileOut != "" && !c.dryRun {
+		if err := j.WriteTextfile(c.textfileOut, c.warnExpiryWithin); err != nil {
+			c.logger.Error("could not write textfile", "path", c.textfileOut, "error", err)
+		}
+	}
+
 	if err != nil {
 		return fmt.Errorf("problem processing keys: %w", err)
 	}
@@ -175,13 +683,137 @@ func (c *rootCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args
 	// did we finish
 	c.logger.Debug("WriteKeys finished")
 
+	// re-publish the supported keys
+	if c.filteredJWKS != "" && !c.dryRun {
+		written, err := j.Supported().WriteJWKS(c.filteredJWKS)
+		if err != nil {
+			return fmt.Errorf("problem writing filtered JWKS: %w", err)
+		}
+
+		if written {
+			c.logger.Info("filtered JWKS written", "path", c.filteredJWKS)
+		}
+	}
+
 	// check if any changes were made
 	if !changed {
 		c.logger.Info("no changes to keys")
 
+		return expiryErr
+	}
+
+	// summarise what changed on disk
+	changes := j.Changes()
+	c.logger.Info("keys updated", "added", changes.Added, "changed", changes.Changed, "removed", changes.Removed)
+
+	// nothing was written so there is nothing to reload
+	if c.dryRun {
+		c.logger.Info("dry run: skipping notification and reload")
+
+		return expiryErr
+	}
+
+	// let others know about the change
+	c.notify(j)
+
+	return errors.Join(c.reload(ctx), expiryErr)
+}
+
+// runPerIssuer fetches the JWKS of every URL and writes the keys of each
+// to its own bundle
+func (c *rootCommand) runPerIssuer(ctx context.Context) error {
+	writeOpts := c.writeOptions()
+	names := issuernames(c.jwksUrls)
+
+	errs := make([]error, 0)
+	changed := false
+	keys := 0
+	for _, url := range c.jwksUrls {
+		start := time.Now()
+		fetchCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		j, err := jwks.GetJWKSContext(fetchCtx, url, c.fetchOptions()...)
+		cancel()
+		c.observefetch(start, err)
+		if errors.Is(err, jwks.ErrNotModified) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("problem fetching JWKS from %s: %w", url, err))
+			continue
+		}
+
+		if err := c.checkexpiry(j); err != nil {
+			errs = append(errs, err)
+		}
+
+		bundle := filepath.Join(c.outputDir, names[url]+".pem")
+		written, err := j.WriteBundle(bundle, writeOpts...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("problem processing keys from %s: %w", url, err))
+		}
+
+		// summarise how each key was handled
+		counts := j.WriteCounts()
+		c.logger.Info("keys processed", "url", url, "written", counts.Written, "unchanged", counts.Unchanged, "skipped", counts.Skipped, "errored", counts.Errored)
+		keys += counts.Written + counts.Unchanged
+
+		if written {
+			c.logger.Info("bundle written", "url", url, "path", bundle)
+			c.notify(j)
+			changed = true
+		}
+	}
+
+	if c.metrics != nil {
+		c.metrics.SetKeys(keys)
+	}
+
+	// reload if any bundle changed, even if others failed
+	if !changed {
+		c.logger.Info("no changes to keys")
+	} else if err := c.reload(ctx); err != nil {
+		errs = append(errs, err)
+	}
+
+	return errors.Join(errs...)
+}
+
+// checkexpiry warns about keys that are about to expire, returning an
+// error if any are found and --fail-on-near-expiry is set
+func (c *rootCommand) checkexpiry(j *jwks.JWKS) error {
+	if c.warnExpiryWithin <= 0 {
 		return nil
 	}
 
+	expiring := j.ExpiringKeys(time.Now(), c.warnExpiryWithin)
+	for _, k := range expiring {
+		c.logger.Warn("key is about to expire", "kid", k.KeyID, "expiry", k.NotAfter, "url", j.URL())
+	}
+
+	if len(expiring) > 0 && c.failOnNearExpiry {
+		return fmt.Errorf("%d keys expire within %s", len(expiring), c.warnExpiryWithin)
+	}
+
+	return nil
+}
+
+// notify sends a summary of the changed keys of j if configured
+func (c *rootCommand) notify(j *jwks.JWKS) {
+	if c.notifier == nil {
+		return
+	}
+
+	if err := c.notifier.Notify(notify.Summary{
+		Changed:   j.ChangedKeys(),
+		Timestamp: time.Now().UTC(),
+		Issuer:    j.URL(),
+	}); err != nil {
+		c.logger.Warn("change notification failed", "url", c.notifier.Info(), "error", err)
+	}
+}
+
+// reload triggers the reloader if configured
+func (c *rootCommand) reload(ctx context.Context) error {
 	// no reload set up?
 	if c.reloader == nil {
 		return nil
@@ -191,19 +823,290 @@ func (c *rootCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args
 	c.logger.Info("changes to keys detected and reloader is configured")
 
 	// do reload
-	if err := c.reloader.Reload(); err != nil {
+	err := c.reloader.Reload(ctx)
+	if c.metrics != nil {
+		c.metrics.ObserveReload(err)
+	}
+	if err != nil {
 		c.logger.Error("reload of process failed", "error", err)
 
 		return err
 	}
 
-	c.logger.Info("reload of process completed")
+	c.logger.Info("reload of process completed", "target", c.reloader.Info())
 
 	return nil
 }
 
+func (c *rootCommand) fetch(ctx context.Context) (*jwks.JWKS, error) {
+	get := jwks.GetJWKSFailoverContext
+	if c.urlMode == "merge" {
+		get = jwks.GetJWKSMergedContext
+	}
+
+	start := time.Now()
+	j, err := get(ctx, c.jwksUrls, c.timeout, c.fetchOptions()...)
+	c.observefetch(start, err)
+
+	return j, err
+}
+
+// observefetch records a fetch that began at start if metrics are enabled
+func (c *rootCommand) observefetch(start time.Time, err error) {
+	if c.metrics == nil {
+		return
+	}
+
+	// an unchanged JWKS is a successful fetch
+	if errors.Is(err, jwks.ErrNotModified) {
+		err = nil
+	}
+
+	c.metrics.ObserveFetch(time.Since(start), err)
+}
+
+func (c *rootCommand) fetchOptions() []jwks.FetchOption {
+	fetchOpts := []jwks.FetchOption{
+		jwks.WithRetries(c.retries, c.retryInterval),
+		jwks.WithMaxBodySize(c.maxBodySize),
+		jwks.WithIdleConns(c.httpMaxIdleConns, c.httpIdleConnTimeout),
+		jwks.WithMaxRedirects(c.maxRedirects),
+		jwks.WithHeaders(c.headers),
+		jwks.WithFetchLogger(c.logger),
+	}
+	if c.lenientParse {
+		fetchOpts = append(fetchOpts, jwks.WithLenientParse())
+	}
+	if !c.publicOnly {
+		fetchOpts = append(fetchOpts, jwks.WithPrivateKeys())
+	}
+	if c.logTLS {
+		fetchOpts = append(fetchOpts, jwks.WithTLSLogging())
+	}
+	if c.tokenFile != "" {
+		fetchOpts = append(fetchOpts, jwks.WithTokenFile(c.tokenFile))
+	}
+	if c.redirectCredentials {
+		fetchOpts = append(fetchOpts, jwks.WithCrossHostCredentials())
+	}
+	if c.tlsClientCert != "" {
+		fetchOpts = append(fetchOpts, jwks.WithClientCertificate(c.tlsClientCert, c.tlsClientKey))
+	}
+	if c.tlsCA != "" {
+		fetchOpts = append(fetchOpts, jwks.WithRootCAs(c.tlsCA))
+	}
+	if c.validators != nil {
+		fetchOpts = append(fetchOpts, jwks.WithValidators(c.validators))
+	}
+	if version, err := tlsversion(c.minTLSVersion); err == nil {
+		fetchOpts = append(fetchOpts, jwks.WithMinTLSVersion(version))
+	}
+
+	return fetchOpts
+}
+
+func (c *rootCommand) writeOptions() []jwks.WriteOption {
+	writeOpts := []jwks.WriteOption{
+		jwks.WithVerifyCommand(strings.Fields(c.verifyCmd)),
+		jwks.WithKeyTypePattern("RSA", c.outputPatternRSA),
+		jwks.WithKeyTypePattern("EC", c.outputPatternEC),
+		jwks.WithKeyTypePattern("OKP", c.outputPatternOKP),
+		jwks.WithAlgorithmMap(c.algMap),
+		jwks.WithUse(c.use),
+		jwks.WithFormat(c.format),
+		jwks.WithFileMode(c.fileMode),
+		jwks.WithLogger(c.logger),
+	}
+	if c.matchDirOwner {
+		writeOpts = append(writeOpts, jwks.WithMatchDirOwner())
+	}
+	if c.semanticCompare {
+		writeOpts = append(writeOpts, jwks.WithSemanticCompare())
+	}
+	if c.splitByAlg || len(c.splitAlgs) > 0 {
+		writeOpts = append(writeOpts, jwks.WithSplitByAlg(c.splitAlgs...))
+	}
+	if c.fingerprintComment {
+		writeOpts = append(writeOpts, jwks.WithFingerprintComment())
+	}
+	if c.followSymlinks {
+		writeOpts = append(writeOpts, jwks.WithFollowSymlinks())
+	}
+	if c.preferX5C {
+		writeOpts = append(writeOpts, jwks.WithPreferX5C())
+	}
+	if c.pemType != jwks.PEMTypePKIX {
+		writeOpts = append(writeOpts, jwks.WithPEMType(c.pemType))
+	}
+	if c.verifyX5C {
+		writeOpts = append(writeOpts, jwks.WithVerifyX5C())
+	}
+	if c.slots > 0 {
+		writeOpts = append(writeOpts, jwks.WithSlots(c.slots))
+	}
+	if c.prune {
+		writeOpts = append(writeOpts, jwks.WithPrune(c.pruneExclude...))
+	}
+	if c.allOrNothing {
+		writeOpts = append(writeOpts, jwks.WithAllOrNothing())
+	}
+	if c.strict {
+		writeOpts = append(writeOpts, jwks.WithStrict())
+	}
+	if c.dryRun {
+		writeOpts = append(writeOpts, jwks.WithDryRun())
+	}
+	if c.mkdir {
+		writeOpts = append(writeOpts, jwks.WithMkdir())
+	}
+	if c.kidHash {
+		writeOpts = append(writeOpts, jwks.WithKIDHash())
+	}
+
+	return writeOpts
+}
+
+// issuernames returns a file name for each URL based on its host, adding
+// the path where several URLs share a host
+func issuernames(urls []string) map[string]string {
+	hosts := make(map[string]int)
+	for _, u := range urls {
+		if parsed, err := url.Parse(u); err == nil {
+			hosts[parsed.Host]++
+		}
+	}
+
+	names := make(map[string]string)
+	for _, u := range urls {
+		parsed, err := url.Parse(u)
+		if err != nil {
+			names[u] = filesafe(u)
+			continue
+		}
+
+		name := parsed.Host
+		if name == "" || hosts[parsed.Host] > 1 {
+			name += parsed.Path
+		}
+		names[u] = filesafe(name)
+	}
+
+	return names
+}
+
+// filesafe replaces characters that are unsafe in a file name
+func filesafe(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-':
+			return r
+		}
+
+		return '_'
+	}, strings.Trim(name, "/"))
+}
+
+// parseheaders converts values of the form "Key: Value" into headers
+func parseheaders(values []string) (http.Header, error) {
+	headers := make(http.Header)
+	for _, v := range values {
+		key, value, ok := strings.Cut(v, ":")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid header, must be in the form \"Key: Value\": %s", v)
+		}
+
+		headers.Add(key, strings.TrimSpace(value))
+	}
+
+	return headers, nil
+}
+
+// tlsversion converts a TLS version such as "1.3" to its constant
+func tlsversion(version string) (uint16, error) {
+	switch version {
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+
+	return 0, fmt.Errorf("unsupported minimum TLS version: %s", version)
+}
+
+// newlogger returns a logger writing to w in the given format, which is
+// "text" or "json", at the named level
+func newlogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unsupported log level: %s", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: l}
+	switch format {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	}
+
+	return nil, fmt.Errorf("unsupported log format: %s", format)
+}
+
+// filemode parses an octal file mode such as "0644"
+func filemode(value string) (fs.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil || mode == 0 || mode > 0777 {
+		return 0, fmt.Errorf("invalid file mode: %s", value)
+	}
+
+	return fs.FileMode(mode), nil
+}
+
+func (c *rootCommand) write(ctx context.Context, j *jwks.JWKS, opts []jwks.WriteOption) (bool, error) {
+	if c.format == "envfile" {
+		// write to stdout if no output is provided
+		if c.outputDir == "" {
+			data, err := j.EnvFile(opts...)
+			os.Stdout.Write(data)
+
+			return false, err
+		}
+
+		return j.WriteEnvFile(filepath.Join(c.outputDir, c.envfileName), opts...)
+	}
+
+	// write all keys to one bundle
+	if c.singleFile != "" {
+		return j.WriteBundle(c.singleFile, opts...)
+	}
+
+	// swap in a complete new set of keys
+	if c.versionedDir {
+		return j.WriteVersioned(ctx, c.outputPattern, c.outputDir, c.keepVersions, opts...)
+	}
+
+	// write keys based on pattern
+	return j.WriteKeys(ctx, c.outputPattern, c.outputDir, opts...)
+}
+
 func Execute(args []string) error {
-	// Set up command
+	// Set up simplecobra
+	x, err := simplecobra.New(newRootCommand())
+	if err != nil {
+		return err
+	}
+
+	// run things
+	if _, err := x.Execute(context.Background(), args); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// newRootCommand returns the root command along with its sub-commands
+func newRootCommand() *rootCommand {
 	root := &rootCommand{
 		Command: simplecommand.New(
 			"jwks-to-pem",
@@ -221,18 +1124,35 @@ func Execute(args []string) error {
 				simplecommand.WithViper("jwks_cron", strings.NewReplacer("-", "_", ".", "_")),
 			),
 		},
+		&watchCommand{
+			Command: simplecommand.New(
+				"watch",
+				"Poll the JWKS at an interval, only downloading it when it has changed",
+				simplecommand.WithViper("jwks_watch", strings.NewReplacer("-", "_", ".", "_")),
+			),
+		},
+		&listCommand{
+			Command: simplecommand.New(
+				"list",
+				"List the keys of the JWKS as JSON",
+				simplecommand.WithViper("jwks_list", strings.NewReplacer("-", "_", ".", "_")),
+			),
+		},
+		&verifyCommand{
+			Command: simplecommand.New(
+				"verify",
+				"Verify a JWT against the keys of the JWKS",
+				simplecommand.WithViper("jwks_verify", strings.NewReplacer("-", "_", ".", "_")),
+			),
+		},
+		&validateCommand{
+			Command: simplecommand.New(
+				"validate",
+				"Check that every key of the JWKS can be converted without writing anything",
+				simplecommand.WithViper("jwks_validate", strings.NewReplacer("-", "_", ".", "_")),
+			),
+		},
 	}
 
-	// Set up simplecobra
-	x, err := simplecobra.New(root)
-	if err != nil {
-		return err
-	}
-
-	// run things
-	if _, err := x.Execute(context.Background(), args); err != nil {
-		return err
-	}
-
-	return nil
+	return root
 }
@@ -2,11 +2,17 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"syscall"
 )
 
+// defaultSignal is sent for reloads unless --reload.signal is set
+const defaultSignal = syscall.SIGHUP
+
 func (sig *signal) Set(s string) error {
+	s = strings.TrimSpace(s)
+
 	switch strings.ToUpper(s) {
 	case "HUP", "SIGHUP":
 		sig.v = syscall.SIGHUP
@@ -18,7 +24,12 @@ func (sig *signal) Set(s string) error {
 		sig.v = syscall.SIGUSR2
 
 	default:
-		return fmt.Errorf("unsupported signal: %s", s)
+		// accept signal numbers as per kill -N
+		n, err := signalnumber(s)
+		if err != nil {
+			return fmt.Errorf("unsupported signal: %s", s)
+		}
+		sig.v = n
 	}
 
 	return nil
@@ -36,5 +47,5 @@ func (sig *signal) String() string {
 		return "SIGUSR2"
 	}
 
-	return "unknown signal"
+	return strconv.Itoa(int(sig.v))
 }
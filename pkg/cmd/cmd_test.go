@@ -0,0 +1,365 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/andrewheberle/jwks-to-pem/pkg/jwks"
+	"github.com/andrewheberle/jwks-to-pem/pkg/metrics"
+	"github.com/bep/simplecobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRootCommand_run_metrics(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	c := &rootCommand{
+		jwksUrls:      []string{ts.URL},
+		outputDir:     t.TempDir(),
+		outputPattern: "{{ .KeyID }}.pem",
+		format:        "pem",
+		timeout:       time.Second * 5,
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:       metrics.New(),
+	}
+
+	ms := httptest.NewServer(c.metrics)
+	defer ms.Close()
+
+	for n, want := range []string{
+		`jwks_to_pem_fetches_total{result="success"} 1`,
+		`jwks_to_pem_fetches_total{result="success"} 2`,
+	} {
+		assert.Nil(t, c.run(context.Background()), "err == nil")
+
+		res, err := http.Get(ms.URL + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+
+		assert.Contains(t, string(body), want, "fetches counted after run %d", n+1)
+		assert.Contains(t, string(body), "jwks_to_pem_keys_written 2\n", "keys written after run %d", n+1)
+	}
+
+	// only the keys selected by --slots are counted
+	c.outputDir = t.TempDir()
+	c.slots = 1
+	assert.Nil(t, c.run(context.Background()), "slots: err == nil")
+
+	res, err := http.Get(ms.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(res.Body)
+	res.Body.Close()
+
+	assert.Contains(t, string(body), "jwks_to_pem_keys_written 1\n", "slots: only the newest key counted")
+}
+
+func TestRootCommand_config(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	reloads := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer reloads.Close()
+
+	dir := t.TempDir()
+	out := filepath.Join(dir, "keys")
+	if err := os.Mkdir(out, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		config  string
+		env     string
+		args    []string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "from file",
+			config: "url: " + ts.URL + "\nout: " + out + "\npattern: \"file-{{ .KeyID }}.pem\"\nreload:\n  url: " + reloads.URL + "\n",
+			want:   "file-{{ .KeyID }}.pem",
+		},
+		{
+			name:   "flag overrides file",
+			config: "url: " + ts.URL + "\nout: " + out + "\npattern: \"file-{{ .KeyID }}.pem\"\n",
+			args:   []string{"--pattern", "flag-{{ .KeyID }}.pem"},
+			want:   "flag-{{ .KeyID }}.pem",
+		},
+		{
+			name:   "env overrides file",
+			config: "url: " + ts.URL + "\nout: " + out + "\npattern: \"file-{{ .KeyID }}.pem\"\n",
+			env:    "env-{{ .KeyID }}.pem",
+			want:   "env-{{ .KeyID }}.pem",
+		},
+		{
+			name:   "flag overrides env",
+			config: "url: " + ts.URL + "\nout: " + out + "\n",
+			env:    "env-{{ .KeyID }}.pem",
+			args:   []string{"--pattern", "flag-{{ .KeyID }}.pem"},
+			want:   "flag-{{ .KeyID }}.pem",
+		},
+		{
+			name:    "conflicting reload options",
+			config:  "url: " + ts.URL + "\nout: " + out + "\nreload:\n  url: " + reloads.URL + "\n  pid: 1\n",
+			wantErr: true,
+		},
+	}
+	for n, tt := range tests {
+		config := filepath.Join(dir, fmt.Sprintf("config-%d.yml", n))
+		if err := os.WriteFile(config, []byte(tt.config), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		t.Setenv("JWKS_PATTERN", tt.env)
+		if tt.env == "" {
+			os.Unsetenv("JWKS_PATTERN")
+		}
+
+		root := newRootCommand()
+		x, err := simplecobra.New(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = x.Execute(context.Background(), append([]string{"--config", config}, tt.args...))
+		if tt.wantErr {
+			assert.ErrorContains(t, err, "none of the others can be", tt.name+": err != nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, []string{ts.URL}, root.jwksUrls, tt.name+": url")
+		assert.Equal(t, out, root.outputDir, tt.name+": out")
+		assert.Equal(t, tt.want, root.outputPattern, tt.name+": pattern")
+	}
+
+	// the reload options from the file were used
+	assert.FileExists(t, filepath.Join(out, "file-rsa-key.pem"), "keys written")
+}
+
+func TestRootCommand_PreRun_bundlePerIssuer(t *testing.T) {
+	for _, flag := range []string{"--error-file", "--textfile-out", "--write-filtered-jwks"} {
+		dir := t.TempDir()
+
+		x, err := simplecobra.New(newRootCommand())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = x.Execute(context.Background(), []string{"--url", "https://issuer-a.example.com/jwks.json", "--url", "https://issuer-b.example.com/jwks.json", "--out", dir, "--bundle-per-issuer", flag, filepath.Join(dir, "out")})
+		assert.ErrorContains(t, err, "cannot be combined", flag+": rejected with --bundle-per-issuer")
+	}
+}
+
+// countReloader counts calls to Reload
+type countReloader struct {
+	n int
+}
+
+func (r *countReloader) Reload(ctx context.Context) error {
+	r.n++
+	return nil
+}
+
+func (r *countReloader) Info() string {
+	return "count"
+}
+
+func TestRootCommand_run_dryRun(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	reloader := new(countReloader)
+	c := &rootCommand{
+		jwksUrls:      []string{ts.URL},
+		outputDir:     t.TempDir(),
+		outputPattern: "{{ .KeyID }}.pem",
+		format:        "pem",
+		timeout:       time.Second * 5,
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		reloader:      reloader,
+		dryRun:        true,
+	}
+
+	assert.Nil(t, c.run(context.Background()), "err == nil")
+	assert.Equal(t, 0, reloader.n, "no reload in a dry run")
+
+	entries, err := os.ReadDir(c.outputDir)
+	assert.Nil(t, err, "err == nil")
+	assert.Empty(t, entries, "no keys written")
+
+	// the same run without dry run writes keys and reloads
+	c.dryRun = false
+	assert.Nil(t, c.run(context.Background()), "err == nil")
+	assert.Equal(t, 1, reloader.n, "reloaded")
+
+	entries, err = os.ReadDir(c.outputDir)
+	assert.Nil(t, err, "err == nil")
+	assert.Len(t, entries, 2, "keys written")
+}
+
+func TestRootCommand_Run_readyFile(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	c := &rootCommand{
+		jwksUrls:      []string{ts.URL},
+		outputDir:     t.TempDir(),
+		outputPattern: "{{ .KeyID }}.pem",
+		format:        "pem",
+		timeout:       time.Second * 5,
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		readyFile:     filepath.Join(dir, "ready"),
+		dryRun:        true,
+	}
+
+	// a dry run leaves a missing ready file missing
+	assert.Nil(t, c.Run(context.Background(), nil, nil), "dry run: err == nil")
+	assert.NoFileExists(t, c.readyFile, "dry run: ready file not written")
+
+	// and an existing one untouched, even if the run fails
+	if err := os.WriteFile(c.readyFile, []byte("previous\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.jwksUrls = []string{"http://127.0.0.1:0"}
+	assert.NotNil(t, c.Run(context.Background(), nil, nil), "failed dry run: err != nil")
+	got, err := os.ReadFile(c.readyFile)
+	assert.Nil(t, err, "failed dry run: ready file kept")
+	assert.Equal(t, "previous\n", string(got), "failed dry run: ready file unchanged")
+
+	// a real run updates it
+	c.jwksUrls = []string{ts.URL}
+	c.dryRun = false
+	assert.Nil(t, c.Run(context.Background(), nil, nil), "err == nil")
+	got, err = os.ReadFile(c.readyFile)
+	assert.Nil(t, err, "ready file written")
+	assert.NotEqual(t, "previous\n", string(got), "ready file updated")
+}
+
+func Test_newlogger(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		level    string
+		wantJSON bool
+		wantLogs int
+		wantErr  bool
+	}{
+		{name: "text", format: "text", level: "info", wantJSON: false, wantLogs: 2},
+		{name: "json", format: "json", level: "info", wantJSON: true, wantLogs: 2},
+		{name: "debug", format: "json", level: "debug", wantJSON: true, wantLogs: 3},
+		{name: "warn", format: "json", level: "WARN", wantJSON: true, wantLogs: 1},
+		{name: "unknown format", format: "xml", level: "info", wantErr: true},
+		{name: "unknown level", format: "text", level: "verbose", wantErr: true},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		logger, err := newlogger(buf, tt.format, tt.level)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		logger.Debug("debug message")
+		logger.Info("info message", "kid", "rsa-key")
+		logger.Warn("warn message")
+
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		assert.Len(t, lines, tt.wantLogs, tt.name+": number of log lines")
+		for _, line := range lines {
+			var entry map[string]any
+			assert.Equal(t, tt.wantJSON, json.Unmarshal(line, &entry) == nil, tt.name+": line parses as JSON")
+		}
+	}
+}
+
+func TestRootCommand_run_validators(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var full int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		full++
+		w.Header().Set("ETag", `"v1"`)
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	c := &rootCommand{
+		jwksUrls:      []string{ts.URL},
+		outputDir:     filepath.Join(t.TempDir(), "keys"),
+		outputPattern: "{{ .KeyID }}.pem",
+		format:        "pem",
+		timeout:       time.Second * 5,
+		logger:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+		validators:    new(jwks.Validators),
+	}
+
+	// the output directory is missing so writing fails
+	assert.NotNil(t, c.run(context.Background()), "err != nil")
+
+	// the next run fetches the JWKS in full rather than getting a 304
+	if err := os.Mkdir(c.outputDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	assert.Nil(t, c.run(context.Background()), "err == nil")
+	assert.Equal(t, 2, full, "JWKS fetched in full after a failed write")
+	assert.FileExists(t, filepath.Join(c.outputDir, "rsa-key.pem"), "keys written")
+
+	// once written an unchanged JWKS is not fetched again
+	assert.Nil(t, c.run(context.Background()), "err == nil")
+	assert.Equal(t, 2, full, "JWKS not modified")
+}
@@ -2,18 +2,41 @@ package cmd
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"syscall"
 )
 
+// supportedSignals lists the signal names accepted on windows
+const supportedSignals = "HUP, INT, TERM, KILL"
+
+// defaultSignal is sent for reloads unless --reload.signal is set
+const defaultSignal = syscall.SIGHUP
+
 func (sig *signal) Set(s string) error {
+	s = strings.TrimSpace(s)
+
 	switch strings.ToUpper(s) {
 	case "HUP", "SIGHUP":
 		sig.v = syscall.SIGHUP
+	case "INT", "SIGINT":
+		sig.v = syscall.SIGINT
+	case "TERM", "SIGTERM":
+		sig.v = syscall.SIGTERM
 	case "KILL", "SIGKILL":
 		sig.v = syscall.SIGKILL
 	default:
-		return fmt.Errorf("unsupported signal: %s", s)
+		// accept signal numbers as per kill -N
+		n, err := signalnumber(s)
+		if err != nil {
+			return fmt.Errorf("unsupported signal on windows: %s (supported signals are %s)", s, supportedSignals)
+		}
+
+		// terminating signals must be asked for by name
+		if n == syscall.SIGTERM || n == syscall.SIGKILL {
+			return fmt.Errorf("signal %s terminates the process so must be given by name (supported signals are %s)", s, supportedSignals)
+		}
+		sig.v = n
 	}
 
 	return nil
@@ -23,9 +46,13 @@ func (sig *signal) String() string {
 	switch sig.v {
 	case syscall.SIGHUP:
 		return "SIGHUP"
+	case syscall.SIGINT:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
 	case syscall.SIGKILL:
 		return "SIGKILL"
 	}
 
-	return "unknown signal"
+	return strconv.Itoa(int(sig.v))
 }
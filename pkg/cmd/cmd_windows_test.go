@@ -0,0 +1,40 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSignal_Set_windows(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{name: "hup", value: "HUP", want: "SIGHUP", wantErr: false},
+		{name: "interrupt", value: "sigint", want: "SIGINT", wantErr: false},
+		{name: "terminate", value: " TERM ", want: "SIGTERM", wantErr: false},
+		{name: "kill", value: "SIGKILL", want: "SIGKILL", wantErr: false},
+		{name: "hup number", value: "1", want: "SIGHUP", wantErr: false},
+		{name: "terminate number", value: "15", wantErr: true},
+		{name: "kill number", value: "9", wantErr: true},
+		{name: "usr1", value: "USR1", wantErr: true},
+		{name: "usr2", value: "SIGUSR2", wantErr: true},
+	}
+	for _, tt := range tests {
+		sig := signal{defaultSignal}
+		err := sig.Set(tt.value)
+		if tt.wantErr {
+			assert.ErrorContains(t, err, "supported signals are", tt.name+": error lists supported signals")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, sig.String(), tt.name+": tt.want == sig.String()")
+	}
+
+	// a reload must not terminate the process by default
+	assert.Equal(t, "SIGHUP", (&signal{defaultSignal}).String(), "default signal is SIGHUP")
+}
@@ -2,18 +2,44 @@ package cmd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"math/rand/v2"
+	"os"
+	ossignal "os/signal"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"github.com/andrewheberle/jwks-to-pem/pkg/metrics"
 	"github.com/andrewheberle/simplecommand"
 	"github.com/bep/simplecobra"
 	"github.com/go-co-op/gocron/v2"
+	"github.com/robfig/cron/v3"
 )
 
 type cronCommand struct {
-	cronPattern string
+	cronPattern           string
+	withSeconds           bool
+	interval              time.Duration
+	requireInitialSuccess bool
+	runOnStart            bool
+	once                  bool
+	maxRunDuration        time.Duration
+	jitter                time.Duration
+	metricsAddr           string
+	healthAddr            string
+	healthMaxFailures     int
 
-	logger *slog.Logger
+	// timedOut counts runs that exceeded maxRunDuration
+	timedOut atomic.Int64
+
+	logger  *slog.Logger
+	metrics *metrics.Metrics
+	health  *health
 
 	*simplecommand.Command
 }
@@ -26,51 +52,284 @@ func (c *cronCommand) Init(cd *simplecobra.Commandeer) error {
 	// command line flags
 	cmd := cd.CobraCommand
 	cmd.Flags().StringVar(&c.cronPattern, "schedule", "", "Cron pattern for scheduling check of JWKS")
+	cmd.Flags().DurationVar(&c.interval, "interval", 0, "Interval between checks of JWKS as an alternative to --schedule, such as 5m")
+	cmd.Flags().BoolVar(&c.withSeconds, "with-seconds", false, "Allow a leading seconds field in the cron pattern, such as \"*/30 * * * * *\"")
+	cmd.Flags().DurationVar(&c.jitter, "jitter", 0, "Delay each scheduled run by a random duration up to this to spread load on the JWKS server")
+	cmd.Flags().DurationVar(&c.maxRunDuration, "max-run-duration", 0, "Cancel a run that takes longer than this so the next run can proceed (0 for no limit)")
+	cmd.Flags().BoolVar(&c.once, "once", false, "Run once and exit without starting the scheduler, such as for smoke tests or one-shot jobs")
+	cmd.Flags().BoolVar(&c.runOnStart, "run-on-start", true, "Run once immediately at startup rather than waiting for the schedule")
+	cmd.Flags().StringVar(&c.metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, such as :9090")
+	cmd.Flags().StringVar(&c.healthAddr, "health-addr", "", "Address to serve /healthz and /readyz on, such as :8080")
+	cmd.Flags().IntVar(&c.healthMaxFailures, "health-max-failures", 3, "Consecutive failed runs before /readyz reports not ready")
+	cmd.Flags().BoolVar(&c.requireInitialSuccess, "require-initial-success", false, "Exit if the first run, at startup or else the first scheduled run, fails rather than waiting for the next one")
 
-	// require a cron pattern
-	cmd.MarkFlagRequired("schedule")
+	// require either a cron pattern or an interval unless only running once
+	cmd.MarkFlagsOneRequired("schedule", "interval", "once")
+	cmd.MarkFlagsMutuallyExclusive("schedule", "interval")
+	cmd.MarkFlagsMutuallyExclusive("with-seconds", "interval")
+	cmd.MarkFlagsMutuallyExclusive("once", "metrics-addr")
+	cmd.MarkFlagsMutuallyExclusive("once", "health-addr")
 
 	return nil
 }
 
 func (c *cronCommand) PreRun(this, runner *simplecobra.Commandeer) error {
+	root, ok := this.Root.Command.(*rootCommand)
+	if !ok {
+		return fmt.Errorf("could not access root command")
+	}
+
+	// load options from the same config file as root
+	c.Config = root.configFile
 	if err := c.Command.PreRun(this, runner); err != nil {
 		return err
 	}
 
 	// inherit logger from root
-	root, ok := this.Root.Command.(*rootCommand)
-	if !ok {
-		return fmt.Errorf("could not access root command")
-	}
 	c.logger = root.logger
 
+	if c.jitter < 0 {
+		return fmt.Errorf("--jitter must not be negative")
+	}
+
+	// fail fast on a schedule that does not suit --with-seconds
+	if c.cronPattern != "" {
+		if err := checkschedule(c.cronPattern, c.withSeconds); err != nil {
+			return err
+		}
+	} else if c.interval <= 0 && !c.once {
+		return fmt.Errorf("--interval must be greater than zero")
+	}
+
+	// record metrics of each run
+	if c.metricsAddr != "" {
+		c.metrics = metrics.New()
+		root.metrics = c.metrics
+	}
+
+	// report health of the scheduler and runs
+	if c.healthAddr != "" {
+		if c.healthMaxFailures < 1 {
+			return fmt.Errorf("--health-max-failures must be at least 1")
+		}
+
+		c.health = &health{maxFailures: int64(c.healthMaxFailures)}
+	}
+
 	return nil
 }
 
 func (c *cronCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	// run a single time without a scheduler
+	if c.once {
+		c.logger.Info("running once")
+
+		return c.run(ctx, cd, args)
+	}
+
 	// set up scheduler
 	s, err := gocron.NewScheduler()
 	if err != nil {
 		return err
 	}
 
+	// stop on SIGINT or SIGTERM
+	ctx, stop := ossignal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// allow a failed first run to stop the daemon
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// let a run in progress finish when stopping
+	runCtx := context.WithoutCancel(ctx)
+
+	// serve metrics and health endpoints until the scheduler has stopped
+	e := make(endpoints)
+	if c.metrics != nil {
+		e.handle(c.metricsAddr, "GET /metrics", c.metrics)
+	}
+	if c.health != nil {
+		c.health.register(e, c.healthAddr)
+	}
+	stopServers, err := e.serve(c.logger)
+	if err != nil {
+		return err
+	}
+	defer stopServers()
+
+	// log failures and keep running unless the first run is required to succeed
+	var first sync.Once
+	task := func() {
+		// spread out runs of many instances sharing the same schedule
+		if !c.wait(ctx) {
+			return
+		}
+
+		err := c.run(runCtx, cd, args)
+		c.record(err)
+		if err != nil {
+			c.logger.Error("scheduled run failed", "error", err)
+		}
+
+		first.Do(func() {
+			if err != nil && c.requireInitialSuccess {
+				cancel(fmt.Errorf("initial run failed: %w", err))
+			}
+		})
+	}
+
 	// add job to scheduler
 	if _, err := s.NewJob(
-		gocron.CronJob(c.cronPattern, false),
-		gocron.NewTask(cd.Root.Command.Run, ctx, cd, args),
+		c.job(),
+		gocron.NewTask(task),
+		// skip a scheduled run while the previous one is still going
+		gocron.WithSingletonMode(gocron.LimitModeReschedule),
 	); err != nil {
 		return err
 	}
 
+	// make sure keys are present before the first scheduled run
+	if c.runOnStart {
+		err := c.run(runCtx, cd, args)
+		c.record(err)
+		if err != nil {
+			c.logger.Error("run at startup failed", "error", err)
+		}
+
+		// this is the initial run so scheduled runs are never required
+		// to succeed
+		first.Do(func() {})
+		if err != nil && c.requireInitialSuccess {
+			if err := s.Shutdown(); err != nil {
+				c.logger.Warn("problem stopping scheduler", "error", err)
+			}
+
+			return fmt.Errorf("initial run failed: %w", err)
+		}
+	}
+
 	// start scheduler
 	s.Start()
+	if c.health != nil {
+		c.health.alive.Store(true)
+	}
 
 	// let them know we started
-	c.logger.Info("starting cron process", "schedule", c.cronPattern)
+	if c.cronPattern != "" {
+		c.logger.Info("starting cron process", "schedule", c.cronPattern)
+	} else {
+		c.logger.Info("starting cron process", "interval", c.interval)
+	}
 
 	// wait until we are done
 	<-ctx.Done()
 
-	return s.Shutdown()
+	// stop scheduling new runs and wait for the current one to complete
+	c.logger.Info("stopping cron process")
+	if c.health != nil {
+		c.health.alive.Store(false)
+	}
+	if err := s.Shutdown(); err != nil {
+		return err
+	}
+
+	// return the reason for stopping if the initial run failed
+	if err := context.Cause(ctx); c.requireInitialSuccess && err != ctx.Err() {
+		return err
+	}
+
+	return nil
+}
+
+// job returns the job definition for the schedule or interval
+func (c *cronCommand) job() gocron.JobDefinition {
+	if c.cronPattern == "" {
+		return gocron.DurationJob(c.interval)
+	}
+
+	return gocron.CronJob(c.cronPattern, c.withSeconds)
+}
+
+// randduration returns a random duration in [0, n) and is replaced in tests
+var randduration = rand.N[time.Duration]
+
+// wait sleeps for a random duration up to jitter before a scheduled run,
+// returning false if ctx is cancelled first
+func (c *cronCommand) wait(ctx context.Context) bool {
+	if c.jitter <= 0 {
+		return true
+	}
+
+	d := randduration(c.jitter)
+	c.logger.Debug("delaying scheduled run", "jitter", d)
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// record updates the health state and metrics after a run if enabled
+func (c *cronCommand) record(err error) {
+	if c.health != nil {
+		c.health.record(err)
+	}
+	if c.metrics != nil {
+		c.metrics.ObserveRun(err, errors.Is(err, errRunTimedOut))
+	}
+}
+
+// errRunTimedOut is returned for a run cancelled after maxRunDuration
+var errRunTimedOut = errors.New("run exceeded maximum duration")
+
+// run performs a single run of the root command, cancelling it once
+// maxRunDuration has passed. The run is always waited for so that it
+// never overlaps the next one.
+func (c *cronCommand) run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	if c.maxRunDuration <= 0 {
+		return cd.Root.Command.Run(ctx, cd, args)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.maxRunDuration)
+	defer cancel()
+
+	err := cd.Root.Command.Run(ctx, cd, args)
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		c.logger.Warn("run exceeded maximum duration and was cancelled", "max-run-duration", c.maxRunDuration, "timed-out-runs", c.timedOut.Add(1))
+
+		return fmt.Errorf("%w of %s: %w", errRunTimedOut, c.maxRunDuration, err)
+	}
+
+	return err
+}
+
+// checkschedule parses pattern in the same way as the scheduler, so an
+// invalid schedule is reported before the scheduler is started
+func checkschedule(pattern string, withSeconds bool) error {
+	var err error
+	if withSeconds {
+		_, err = cron.NewParser(cron.SecondOptional | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor).Parse(pattern)
+	} else {
+		_, err = cron.ParseStandard(pattern)
+	}
+	if err == nil {
+		return nil
+	}
+
+	// a seconds field is the likely cause of six fields
+	fields := strings.Fields(pattern)
+	if len(fields) > 0 && (strings.HasPrefix(fields[0], "TZ=") || strings.HasPrefix(fields[0], "CRON_TZ=")) {
+		fields = fields[1:]
+	}
+	if !withSeconds && len(fields) == 6 {
+		return fmt.Errorf("invalid schedule %q: it has six fields, add --with-seconds to schedule with seconds", pattern)
+	}
+
+	return fmt.Errorf("invalid schedule %q: %w", pattern, err)
 }
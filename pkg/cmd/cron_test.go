@@ -0,0 +1,257 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/andrewheberle/jwks-to-pem/pkg/metrics"
+	"github.com/andrewheberle/simplecommand"
+	"github.com/bep/simplecobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCronCommand_Run_shutdown(t *testing.T) {
+	c := &cronCommand{
+		// a schedule that will not fire during the test
+		cronPattern: "0 0 1 1 *",
+		logger:      slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx, nil, nil)
+	}()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err, "err == nil after shutdown")
+	case <-time.After(time.Second * 5):
+		t.Fatal("scheduler did not shut down")
+	}
+}
+
+// testRootCommand counts runs in place of the root command
+type testRootCommand struct {
+	runs atomic.Int32
+	err  error
+
+	*simplecommand.Command
+}
+
+func (c *testRootCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	c.runs.Add(1)
+
+	return c.err
+}
+
+func TestCronCommand_Run_runOnStart(t *testing.T) {
+	tests := []struct {
+		name                  string
+		runOnStart            bool
+		requireInitialSuccess bool
+		err                   error
+		wantRuns              int32
+		wantErr               bool
+	}{
+		{name: "run on start", runOnStart: true, wantRuns: 1, wantErr: false},
+		{name: "wait for schedule", runOnStart: false, wantRuns: 0, wantErr: false},
+		{name: "failure logged", runOnStart: true, err: errors.New("failed"), wantRuns: 1, wantErr: false},
+		{name: "require initial success", runOnStart: true, requireInitialSuccess: true, err: errors.New("failed"), wantRuns: 1, wantErr: true},
+		{name: "initial success", runOnStart: true, requireInitialSuccess: true, wantRuns: 1, wantErr: false},
+	}
+	for _, tt := range tests {
+		root := &testRootCommand{err: tt.err, Command: simplecommand.New("test", "test")}
+		cd := &simplecobra.Commandeer{Command: root}
+		cd.Root = cd
+
+		c := &cronCommand{
+			// a schedule that will not fire during the test
+			cronPattern:           "0 0 1 1 *",
+			runOnStart:            tt.runOnStart,
+			requireInitialSuccess: tt.requireInitialSuccess,
+			logger:                slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+		err := c.Run(ctx, cd, nil)
+		cancel()
+
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+		assert.Equal(t, tt.wantRuns, root.runs.Load(), tt.name+": runs before first scheduled run")
+	}
+}
+
+func Test_checkschedule(t *testing.T) {
+	tests := []struct {
+		name        string
+		pattern     string
+		withSeconds bool
+		wantErr     bool
+	}{
+		{name: "five fields", pattern: "*/5 * * * *", withSeconds: false, wantErr: false},
+		{name: "six fields", pattern: "*/30 * * * * *", withSeconds: false, wantErr: true},
+		{name: "six fields with seconds", pattern: "*/30 * * * * *", withSeconds: true, wantErr: false},
+		{name: "five fields with seconds", pattern: "*/5 * * * *", withSeconds: true, wantErr: false},
+		{name: "time zone with seconds", pattern: "TZ=UTC */30 * * * * *", withSeconds: true, wantErr: false},
+		{name: "descriptor", pattern: "@hourly", withSeconds: false, wantErr: false},
+		{name: "invalid", pattern: "not a schedule", withSeconds: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		err := checkschedule(tt.pattern, tt.withSeconds)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+	}
+
+	// the error suggests --with-seconds
+	assert.ErrorContains(t, checkschedule("*/30 * * * * *", false), "--with-seconds", "hint given")
+}
+
+func TestCronCommand_wait(t *testing.T) {
+	// always wait for the full jitter
+	randduration = func(n time.Duration) time.Duration { return n }
+	t.Cleanup(func() { randduration = rand.N[time.Duration] })
+
+	tests := []struct {
+		name     string
+		jitter   time.Duration
+		cancel   bool
+		want     bool
+		wantWait time.Duration
+	}{
+		{name: "no jitter", jitter: 0, want: true, wantWait: 0},
+		{name: "jitter", jitter: time.Millisecond * 200, want: true, wantWait: time.Millisecond * 200},
+		{name: "cancelled", jitter: time.Hour, cancel: true, want: false, wantWait: 0},
+	}
+	for _, tt := range tests {
+		c := &cronCommand{
+			jitter: tt.jitter,
+			logger: slog.New(slog.NewTextHandler(io.Discard, nil)),
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		if tt.cancel {
+			time.AfterFunc(time.Millisecond*50, cancel)
+		}
+
+		start := time.Now()
+		got := c.wait(ctx)
+		waited := time.Since(start)
+		cancel()
+
+		assert.Equal(t, tt.want, got, tt.name+": tt.want == got")
+		assert.GreaterOrEqual(t, waited, tt.wantWait, tt.name+": waited before the run")
+		assert.Less(t, waited, tt.wantWait+time.Second, tt.name+": did not wait too long")
+	}
+}
+
+func TestCronCommand_Run_interval(t *testing.T) {
+	root := &testRootCommand{Command: simplecommand.New("test", "test")}
+	cd := &simplecobra.Commandeer{Command: root}
+	cd.Root = cd
+
+	c := &cronCommand{
+		interval:   time.Millisecond * 100,
+		runOnStart: false,
+		logger:     slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*350)
+	defer cancel()
+
+	assert.Nil(t, c.Run(ctx, cd, nil), "err == nil")
+	assert.GreaterOrEqual(t, root.runs.Load(), int32(2), "runs every interval")
+}
+
+func TestCronCommand_Run_once(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	defer ts.Close()
+
+	out := t.TempDir()
+
+	x, err := simplecobra.New(newRootCommand())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err = x.Execute(ctx, []string{"cron", "--once", "--url", ts.URL, "--out", out, "--log-level", "error"})
+	assert.Nil(t, err, "err == nil")
+	assert.Nil(t, ctx.Err(), "returned before timeout")
+	assert.FileExists(t, filepath.Join(out, "rsa-key.pem"), "keys written")
+}
+
+// slowRootCommand blocks each run until it is cancelled
+type slowRootCommand struct {
+	running atomic.Int32
+	overlap atomic.Bool
+
+	*simplecommand.Command
+}
+
+func (c *slowRootCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	if c.running.Add(1) > 1 {
+		c.overlap.Store(true)
+	}
+	defer c.running.Add(-1)
+
+	<-ctx.Done()
+
+	return ctx.Err()
+}
+
+func TestCronCommand_run_maxRunDuration(t *testing.T) {
+	root := &slowRootCommand{Command: simplecommand.New("test", "test")}
+	cd := &simplecobra.Commandeer{Command: root}
+	cd.Root = cd
+
+	c := &cronCommand{
+		interval:       time.Millisecond * 20,
+		maxRunDuration: time.Millisecond * 50,
+		runOnStart:     true,
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:        metrics.New(),
+	}
+
+	// a timed out run is a failure
+	err := c.run(context.Background(), cd, nil)
+	assert.ErrorIs(t, err, context.DeadlineExceeded, "errors.Is(err, context.DeadlineExceeded)")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*300)
+	defer cancel()
+
+	assert.Nil(t, c.Run(ctx, cd, nil), "err == nil")
+	assert.GreaterOrEqual(t, c.timedOut.Load(), int64(2), "timed out runs counted")
+	assert.False(t, root.overlap.Load(), "runs never overlap")
+	assert.Equal(t, int32(0), root.running.Load(), "no run left running")
+	assert.Contains(t, string(c.metrics.Text()), `jwks_to_pem_runs_total{result="success"} 0`+"\n", "no successful runs")
+	assert.NotContains(t, string(c.metrics.Text()), `jwks_to_pem_runs_total{result="timeout"} 0`+"\n", "timed out runs counted")
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"net/http"
+	"sync/atomic"
+)
+
+// health tracks the state reported by the liveness and readiness
+// endpoints
+type health struct {
+	// alive is set while the scheduler is running
+	alive atomic.Bool
+
+	// ready is set after a successful run and cleared once maxFailures
+	// runs in a row have failed
+	ready       atomic.Bool
+	failures    atomic.Int64
+	maxFailures int64
+}
+
+// record updates the state after a run that failed if err is not nil
+func (h *health) record(err error) {
+	if err == nil {
+		h.failures.Store(0)
+		h.ready.Store(true)
+		return
+	}
+
+	if h.failures.Add(1) >= h.maxFailures {
+		h.ready.Store(false)
+	}
+}
+
+// register adds the /healthz and /readyz endpoints on addr
+func (h *health) register(e endpoints, addr string) {
+	e.handle(addr, "GET /healthz", probe(&h.alive))
+	e.handle(addr, "GET /readyz", probe(&h.ready))
+}
+
+// probe responds with 200 OK if state is set or 503 Service Unavailable
+// otherwise
+func probe(state *atomic.Bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !state.Load() {
+			http.Error(w, "not ok", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.Write([]byte("ok\n"))
+	})
+}
@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealth(t *testing.T) {
+	h := &health{maxFailures: 2}
+
+	e := make(endpoints)
+	h.register(e, "test")
+	ts := httptest.NewServer(e["test"])
+	defer ts.Close()
+
+	status := func(path string) int {
+		res, err := http.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+
+		return res.StatusCode
+	}
+
+	tests := []struct {
+		name        string
+		step        func()
+		wantHealthz int
+		wantReadyz  int
+	}{
+		{name: "before start", step: func() {}, wantHealthz: http.StatusServiceUnavailable, wantReadyz: http.StatusServiceUnavailable},
+		{name: "scheduler running", step: func() { h.alive.Store(true) }, wantHealthz: http.StatusOK, wantReadyz: http.StatusServiceUnavailable},
+		{name: "first run failed", step: func() { h.record(errors.New("failed")) }, wantHealthz: http.StatusOK, wantReadyz: http.StatusServiceUnavailable},
+		{name: "successful run", step: func() { h.record(nil) }, wantHealthz: http.StatusOK, wantReadyz: http.StatusOK},
+		{name: "one failure", step: func() { h.record(errors.New("failed")) }, wantHealthz: http.StatusOK, wantReadyz: http.StatusOK},
+		{name: "too many failures", step: func() { h.record(errors.New("failed")) }, wantHealthz: http.StatusOK, wantReadyz: http.StatusServiceUnavailable},
+		{name: "recovered", step: func() { h.record(nil) }, wantHealthz: http.StatusOK, wantReadyz: http.StatusOK},
+		{name: "stopping", step: func() { h.alive.Store(false) }, wantHealthz: http.StatusServiceUnavailable, wantReadyz: http.StatusOK},
+	}
+	for _, tt := range tests {
+		tt.step()
+
+		assert.Equal(t, tt.wantHealthz, status("/healthz"), tt.name+": /healthz")
+		assert.Equal(t, tt.wantReadyz, status("/readyz"), tt.name+": /readyz")
+	}
+}
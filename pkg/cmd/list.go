@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/andrewheberle/simplecommand"
+	"github.com/bep/simplecobra"
+)
+
+type listCommand struct {
+	jsonl bool
+
+	*simplecommand.Command
+}
+
+func (c *listCommand) Init(cd *simplecobra.Commandeer) error {
+	if err := c.Command.Init(cd); err != nil {
+		return err
+	}
+
+	// command line flags
+	cmd := cd.CobraCommand
+	cmd.Flags().BoolVar(&c.jsonl, "jsonl", false, "Output one compact JSON object per line rather than a JSON array")
+
+	return nil
+}
+
+func (c *listCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	root, ok := cd.Root.Command.(*rootCommand)
+	if !ok {
+		return fmt.Errorf("could not access root command")
+	}
+
+	// fetch JWKS
+	j, err := root.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("problem fetching JWKS: %w", err)
+	}
+
+	return j.WriteSummary(os.Stdout, c.jsonl)
+}
@@ -0,0 +1,75 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+// endpoints collects the handlers to serve on each address so that
+// several may share a listener
+type endpoints map[string]*http.ServeMux
+
+// handle registers handler for pattern on addr
+func (e endpoints) handle(addr, pattern string, handler http.Handler) {
+	mux, ok := e[addr]
+	if !ok {
+		mux = http.NewServeMux()
+		e[addr] = mux
+	}
+
+	mux.Handle(pattern, handler)
+}
+
+// serve starts a server on each address, returning a function that shuts
+// them all down
+func (e endpoints) serve(logger *slog.Logger) (func(), error) {
+	stops := make([]func(), 0, len(e))
+	stopAll := func() {
+		for _, stop := range stops {
+			stop()
+		}
+	}
+
+	for addr, mux := range e {
+		stop, err := serve(addr, mux, logger)
+		if err != nil {
+			stopAll()
+			return nil, err
+		}
+		stops = append(stops, stop)
+	}
+
+	return stopAll, nil
+}
+
+// serve starts a HTTP server for handler on addr in the background,
+// returning a function that shuts it down
+func serve(addr string, handler http.Handler, logger *slog.Logger) (func(), error) {
+	// listen now so a bad address is reported straight away
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	srv := &http.Server{Handler: handler, ReadHeaderTimeout: time.Second * 5}
+	go func() {
+		if err := srv.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("http server failed", "addr", addr, "error", err)
+		}
+	}()
+
+	logger.Info("http server listening", "addr", l.Addr().String())
+
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			logger.Warn("problem stopping http server", "addr", addr, "error", err)
+		}
+	}, nil
+}
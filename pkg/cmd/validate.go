@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/andrewheberle/simplecommand"
+	"github.com/bep/simplecobra"
+)
+
+type validateCommand struct {
+	jsonl bool
+
+	*simplecommand.Command
+}
+
+func (c *validateCommand) Init(cd *simplecobra.Commandeer) error {
+	if err := c.Command.Init(cd); err != nil {
+		return err
+	}
+
+	// command line flags
+	cmd := cd.CobraCommand
+	cmd.Flags().BoolVar(&c.jsonl, "jsonl", false, "Output one compact JSON object per line, as for list, rather than a table")
+
+	return nil
+}
+
+func (c *validateCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	root, ok := cd.Root.Command.(*rootCommand)
+	if !ok {
+		return fmt.Errorf("could not access root command")
+	}
+
+	// fetch JWKS
+	j, err := root.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("problem fetching JWKS: %w", err)
+	}
+
+	// report the status of each key
+	keys := j.Summary()
+	failed := 0
+	for _, ks := range keys {
+		if ks.Error != "" {
+			failed++
+		}
+	}
+
+	if c.jsonl {
+		if err := j.WriteSummary(os.Stdout, true); err != nil {
+			return err
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KID\tALG\tSTATUS")
+		for _, ks := range keys {
+			status := "ok"
+			if ks.Error != "" {
+				status = "error: " + ks.Error
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", ks.KeyID, ks.ALG, status)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d keys could not be converted", failed, len(keys))
+	}
+
+	return nil
+}
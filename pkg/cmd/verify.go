@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/andrewheberle/simplecommand"
+	"github.com/bep/simplecobra"
+)
+
+type verifyCommand struct {
+	token string
+
+	logger *slog.Logger
+
+	*simplecommand.Command
+}
+
+func (c *verifyCommand) Init(cd *simplecobra.Commandeer) error {
+	if err := c.Command.Init(cd); err != nil {
+		return err
+	}
+
+	// command line flags
+	cmd := cd.CobraCommand
+	cmd.Flags().StringVar(&c.token, "token", "", "JWT to verify against the keys of the JWKS")
+
+	// require a token
+	cmd.MarkFlagRequired("token")
+
+	return nil
+}
+
+func (c *verifyCommand) PreRun(this, runner *simplecobra.Commandeer) error {
+	if err := c.Command.PreRun(this, runner); err != nil {
+		return err
+	}
+
+	// inherit logger from root
+	root, ok := this.Root.Command.(*rootCommand)
+	if !ok {
+		return fmt.Errorf("could not access root command")
+	}
+	c.logger = root.logger
+
+	return nil
+}
+
+func (c *verifyCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	root, ok := cd.Root.Command.(*rootCommand)
+	if !ok {
+		return fmt.Errorf("could not access root command")
+	}
+
+	// fetch JWKS
+	j, err := root.fetch(ctx)
+	if err != nil {
+		return fmt.Errorf("problem fetching JWKS: %w", err)
+	}
+
+	// check the token
+	token, err := j.VerifyToken(c.token)
+	if token != nil {
+		c.logger.Debug("decoded token", "header", string(token.Header), "claims", string(token.Claims))
+	}
+	if err != nil {
+		c.logger.Error("token verification failed", "error", err)
+
+		return err
+	}
+
+	c.logger.Info("token verified", "kid", token.KeyID, "alg", token.ALG, "url", j.URL())
+
+	return nil
+}
@@ -0,0 +1,115 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	ossignal "os/signal"
+	"syscall"
+	"time"
+
+	"github.com/andrewheberle/jwks-to-pem/pkg/jwks"
+	"github.com/andrewheberle/jwks-to-pem/pkg/metrics"
+	"github.com/andrewheberle/simplecommand"
+	"github.com/bep/simplecobra"
+)
+
+type watchCommand struct {
+	interval    time.Duration
+	metricsAddr string
+
+	logger  *slog.Logger
+	metrics *metrics.Metrics
+
+	*simplecommand.Command
+}
+
+func (c *watchCommand) Init(cd *simplecobra.Commandeer) error {
+	if err := c.Command.Init(cd); err != nil {
+		return err
+	}
+
+	// command line flags
+	cmd := cd.CobraCommand
+	cmd.Flags().DurationVar(&c.interval, "interval", time.Second*30, "Interval between checks of the JWKS")
+	cmd.Flags().StringVar(&c.metricsAddr, "metrics-addr", "", "Address to serve Prometheus metrics on at /metrics, such as :9090")
+
+	return nil
+}
+
+func (c *watchCommand) PreRun(this, runner *simplecobra.Commandeer) error {
+	root, ok := this.Root.Command.(*rootCommand)
+	if !ok {
+		return fmt.Errorf("could not access root command")
+	}
+
+	// load options from the same config file as root
+	c.Config = root.configFile
+	if err := c.Command.PreRun(this, runner); err != nil {
+		return err
+	}
+
+	if c.interval <= 0 {
+		return fmt.Errorf("--interval must be greater than zero")
+	}
+
+	// inherit logger from root
+	c.logger = root.logger
+
+	// make fetches conditional on the JWKS having changed
+	root.validators = new(jwks.Validators)
+
+	// record metrics of each run
+	if c.metricsAddr != "" {
+		c.metrics = metrics.New()
+		root.metrics = c.metrics
+	}
+
+	return nil
+}
+
+func (c *watchCommand) Run(ctx context.Context, cd *simplecobra.Commandeer, args []string) error {
+	// stop on SIGINT or SIGTERM
+	ctx, stop := ossignal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// let a run in progress finish when stopping
+	runCtx := context.WithoutCancel(ctx)
+
+	// serve metrics until we stop
+	if c.metrics != nil {
+		e := make(endpoints)
+		e.handle(c.metricsAddr, "GET /metrics", c.metrics)
+
+		stopServers, err := e.serve(c.logger)
+		if err != nil {
+			return err
+		}
+		defer stopServers()
+	}
+
+	// let them know we started
+	c.logger.Info("starting watch process", "interval", c.interval)
+
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		err := cd.Root.Command.Run(runCtx, cd, args)
+		if c.metrics != nil {
+			c.metrics.ObserveRun(err, false)
+		}
+		if err != nil {
+			c.logger.Error("watch run failed", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			c.logger.Info("stopping watch process")
+
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
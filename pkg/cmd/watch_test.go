@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/andrewheberle/simplecommand"
+	"github.com/bep/simplecobra"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchCommand_Run(t *testing.T) {
+	root := &testRootCommand{Command: simplecommand.New("test", "test")}
+	cd := &simplecobra.Commandeer{Command: root}
+	cd.Root = cd
+
+	c := &watchCommand{
+		interval: time.Millisecond * 50,
+		logger:   slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*175)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Run(ctx, cd, nil)
+	}()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err, "err == nil after shutdown")
+	case <-time.After(time.Second * 5):
+		t.Fatal("watch did not shut down")
+	}
+
+	// one run at startup and then one per interval
+	assert.GreaterOrEqual(t, root.runs.Load(), int32(3), "runs at startup and each interval")
+}
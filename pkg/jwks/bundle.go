@@ -0,0 +1,193 @@
+package jwks
+
+import (
+	"bytes"
+	"crypto"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// bundleMarker starts the block of each key within a bundle
+const bundleMarker = "# kid: "
+
+// WriteBundle writes every key of the JWKS to a single bundle at "name"
+// in key ID order, with the block of each key preceded by a "# kid:"
+// marker line. The blocks of an existing bundle are reused byte for byte
+// for keys that have not changed, so only the blocks of changed keys are
+// rewritten.
+func (j *JWKS) WriteBundle(name string, opts ...WriteOption) (bool, error) {
+	// apply options
+	options := new(writeOptions)
+	for _, o := range opts {
+		o(options)
+	}
+
+	// create the directory of the bundle if allowed
+	if options.mkdir {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return false, &WriteError{Message: "could not create directory", Err: err}
+		}
+	}
+
+	// load blocks of the existing bundle
+	b, err := os.ReadFile(name)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, &WriteError{Message: "could not read existing bundle", Err: err}
+	}
+	existing := bundleblocks(b)
+
+	// look up owner of output directory
+	if options.matchDirOwner {
+		uid, gid, err := dirowner(filepath.Dir(name))
+		if errors.Is(err, errors.ErrUnsupported) {
+			options.log().Warn("matching the owner of the output directory is not supported on this platform")
+		} else if err != nil {
+			return false, &WriteError{Message: "could not determine owner of output directory", Err: err}
+		} else {
+			options.owner = &fileowner{uid, gid}
+		}
+	}
+
+	// keep track of errors
+	errs := make([]error, 0)
+
+	// reset list of changed keys
+	j.changes = Changes{}
+	j.counts = WriteCounts{}
+
+	// build bundle in a stable order
+	keys := slices.Clone(filteruse(j.keyset, options.use))
+	slices.SortStableFunc(keys, func(a, b *JWK) int {
+		return strings.Compare(a.KID(), b.KID())
+	})
+
+	// write nothing unless every key can be converted
+	if options.allOrNothing {
+		if err := validateall(keys, options); err != nil {
+			j.counts.Errored = len(keys)
+			return false, err
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	for _, jwk := range keys {
+		keyID := jwk.KID()
+
+		data, err := jwk.encode(options)
+		if err != nil {
+			if unsupported(err) {
+				j.counts.Skipped++
+			} else {
+				j.counts.Errored++
+			}
+			if !options.skip(jwk, err) {
+				errs = append(errs, options.keyerror(jwk, err))
+			}
+			continue
+		}
+
+		// keep the existing block if the key is unchanged
+		if block, ok := existing[keyID]; ok && !bundlechanged(block, data, options) {
+			options.log().Debug("keeping unchanged key in bundle", "kid", keyID, "alg", jwk.ALG(), "path", name)
+			buf.Write(block)
+			j.counts.Unchanged++
+			continue
+		}
+
+		options.log().Debug("adding key to bundle", "kid", keyID, "alg", jwk.ALG(), "path", name)
+		buf.WriteString(bundleMarker + keyID + "\n")
+		buf.Write(data)
+		j.counts.Written++
+		if _, ok := existing[keyID]; ok {
+			j.changes.Changed = append(j.changes.Changed, keyID)
+		} else {
+			j.changes.Added = append(j.changes.Added, keyID)
+		}
+	}
+
+	// check if any changes have occurred
+	if changed, err := keychanged(name, buf.Bytes()); err != nil {
+		return false, errors.Join(append(errs, &WriteError{Message: "error comparing bundle", Err: err})...)
+	} else if !changed {
+		j.changes = Changes{}
+		j.counts.Unchanged += j.counts.Written
+		j.counts.Written = 0
+
+		return false, errors.Join(errs...)
+	}
+
+	if err := writefile(name, "", buf.Bytes(), options); err != nil {
+		// nothing reached the disk
+		j.changes = Changes{}
+		j.counts.Errored += j.counts.Written + j.counts.Unchanged
+		j.counts.Written, j.counts.Unchanged = 0, 0
+
+		return false, errors.Join(append(errs, &WriteError{Message: "writing bundle failed", Err: err})...)
+	}
+
+	return true, errors.Join(errs...)
+}
+
+// bundleblocks splits a bundle into the block of each key, including
+// its marker line, by key ID
+func bundleblocks(data []byte) map[string][]byte {
+	blocks := make(map[string][]byte)
+
+	var kid string
+	var block []byte
+	for line := range bytes.Lines(data) {
+		if after, ok := bytes.CutPrefix(line, []byte(bundleMarker)); ok {
+			if block != nil {
+				blocks[kid] = block
+			}
+
+			kid = strings.TrimRight(string(after), "\r\n")
+			block = nil
+		} else if block == nil {
+			// ignore anything before the first marker
+			continue
+		}
+
+		block = append(block, line...)
+	}
+	if block != nil {
+		blocks[kid] = block
+	}
+
+	return blocks
+}
+
+// bundlechanged reports if the existing block for a key differs from
+// the newly encoded data
+func bundlechanged(block, data []byte, options *writeOptions) bool {
+	// strip marker line
+	_, current, _ := bytes.Cut(block, []byte("\n"))
+	if bytes.Equal(current, data) {
+		return false
+	}
+
+	if !options.semanticCompare || formatof(current) != formatof(data) {
+		return true
+	}
+
+	currentKey, err := parsepem(current)
+	if err != nil {
+		return true
+	}
+
+	newKey, err := parsepem(data)
+	if err != nil {
+		return true
+	}
+
+	k, ok := newKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return true
+	}
+
+	return !k.Equal(currentKey)
+}
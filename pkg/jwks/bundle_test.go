@@ -0,0 +1,64 @@
+package jwks
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_bundleblocks(t *testing.T) {
+	data := []byte("preamble\n# kid: a\nfirst\n# kid: b\nsecond\nmore\n")
+	blocks := bundleblocks(data)
+
+	assert.Len(t, blocks, 2, "two blocks")
+	assert.Equal(t, "# kid: a\nfirst\n", string(blocks["a"]), "block a")
+	assert.Equal(t, "# kid: b\nsecond\nmore\n", string(blocks["b"]), "block b")
+}
+
+func TestJWKS_WriteBundle(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "bundle.pem")
+	j := testJWKS(t)
+
+	changed, err := j.WriteBundle(name)
+	assert.Nil(t, err, "err == nil")
+	assert.True(t, changed, "first write changed")
+	assert.Equal(t, []string{"ec-key", "rsa-key"}, j.ChangedKeys(), "all keys written in kid order")
+	assert.Equal(t, Changes{Added: []string{"ec-key", "rsa-key"}}, j.Changes(), "all keys added")
+	assert.Equal(t, WriteCounts{Written: 2}, j.WriteCounts(), "all keys counted as written")
+
+	changed, err = j.WriteBundle(name)
+	assert.Nil(t, err, "err == nil")
+	assert.False(t, changed, "second write unchanged")
+	assert.Equal(t, Changes{}, j.Changes(), "no changes")
+	assert.Equal(t, WriteCounts{Unchanged: 2}, j.WriteCounts(), "all keys counted as unchanged")
+
+	// annotate the block of one key without changing the key itself
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	annotated := strings.Replace(string(b), "# kid: rsa-key\n", "# kid: rsa-key\n# do not edit\n", 1)
+	if err := os.WriteFile(name, []byte(annotated), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changed, err = j.WriteBundle(name, WithSemanticCompare())
+	assert.Nil(t, err, "err == nil")
+	assert.False(t, changed, "semantically unchanged block kept")
+
+	changed, err = j.WriteBundle(name)
+	assert.Nil(t, err, "err == nil")
+	assert.True(t, changed, "changed block rewritten")
+	assert.Equal(t, []string{"rsa-key"}, j.ChangedKeys(), "only changed key rewritten")
+	assert.Equal(t, Changes{Changed: []string{"rsa-key"}}, j.Changes(), "existing key changed")
+	assert.Equal(t, WriteCounts{Written: 1, Unchanged: 1}, j.WriteCounts(), "one key written and one unchanged")
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, b, got, "bundle restored")
+}
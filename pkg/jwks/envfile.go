@@ -0,0 +1,131 @@
+package jwks
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// envfilePrefix is prepended to the variable name of each key
+const envfilePrefix = "JWT_KEY_"
+
+// EnvFile returns the PEM encoded keys of the JWKS as a dotenv style
+// file with one JWT_KEY_<KID> variable per key. Keys are filtered by use
+// and unsupported keys are skipped in the same way as WriteKeys, while
+// other keys that could not be encoded are skipped and returned as an
+// error.
+func (j *JWKS) EnvFile(opts ...WriteOption) ([]byte, error) {
+	options := new(writeOptions)
+	for _, o := range opts {
+		o(options)
+	}
+
+	return j.envfile(options)
+}
+
+func (j *JWKS) envfile(options *writeOptions) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	seen := make(map[string]bool)
+	errs := make([]error, 0)
+
+	// reset counts
+	j.counts = WriteCounts{}
+
+	// only write keys for the requested use
+	for n, jwk := range filteruse(j.keyset, options.use) {
+		data, err := jwk.PEMAs(options.pemType)
+		if err != nil {
+			if unsupported(err) {
+				j.counts.Skipped++
+			} else {
+				j.counts.Errored++
+			}
+			if !options.skip(jwk, err) {
+				errs = append(errs, options.keyerror(jwk, err))
+			}
+			continue
+		}
+
+		// avoid two keys sanitizing to the same name
+		name := envname(jwk.KID(), n)
+		if seen[name] {
+			name = fmt.Sprintf("%s_%d", name, n)
+		}
+		seen[name] = true
+
+		fmt.Fprintf(buf, "%s=\"%s\"\n", name, envescape(string(data)))
+		j.counts.Written++
+	}
+
+	return buf.Bytes(), errors.Join(errs...)
+}
+
+// WriteEnvFile writes the keys of the JWKS as a dotenv style file to
+// "name" if it has changed
+func (j *JWKS) WriteEnvFile(name string, opts ...WriteOption) (bool, error) {
+	options := new(writeOptions)
+	for _, o := range opts {
+		o(options)
+	}
+
+	// create the directory of the env file if allowed
+	if options.mkdir {
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return false, &WriteError{Message: "could not create directory", Err: err}
+		}
+	}
+
+	data, keyErr := j.envfile(options)
+
+	// check if any changes have occurred
+	if changed, err := keychanged(name, data); err != nil {
+		return false, &WriteError{Message: "error comparing env file", Err: err}
+	} else if !changed {
+		j.counts.Unchanged += j.counts.Written
+		j.counts.Written = 0
+
+		return false, keyErr
+	}
+
+	// the verify command checks a single key rather than an env file
+	fileOptions := *options
+	fileOptions.verify = nil
+
+	if err := writefile(name, "", data, &fileOptions); err != nil {
+		j.counts.Errored += j.counts.Written
+		j.counts.Written = 0
+
+		return false, errors.Join(keyErr, &WriteError{Message: "writing env file failed", Err: err})
+	}
+
+	return true, keyErr
+}
+
+// envname converts a key id into a valid environment variable name,
+// falling back to the index of the key when there is no key id
+func envname(kid string, index int) string {
+	if kid == "" {
+		return fmt.Sprintf("%s%d", envfilePrefix, index)
+	}
+
+	return envfilePrefix + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		}
+
+		return '_'
+	}, kid)
+}
+
+var envreplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`, "\n", `\n`)
+
+// envescape escapes a value for use inside double quotes in an env file
+func envescape(value string) string {
+	return envreplacer.Replace(value)
+}
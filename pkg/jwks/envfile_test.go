@@ -0,0 +1,115 @@
+package jwks
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_envname(t *testing.T) {
+	tests := []struct {
+		name  string
+		kid   string
+		index int
+		want  string
+	}{
+		{name: "simple", kid: "abc123", index: 0, want: "JWT_KEY_ABC123"},
+		{name: "unsafe characters", kid: "https://example.com/key-1", index: 0, want: "JWT_KEY_HTTPS___EXAMPLE_COM_KEY_1"},
+		{name: "empty kid", kid: "", index: 2, want: "JWT_KEY_2"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, envname(tt.kid, tt.index), tt.name+": tt.want == got")
+	}
+}
+
+func TestJWKS_WriteEnvFile(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "keys.env")
+	j := testJWKS(t)
+
+	changed, err := j.WriteEnvFile(name)
+	assert.Nil(t, err, "err == nil")
+	assert.True(t, changed, "first write changed")
+	assert.Equal(t, WriteCounts{Written: 2}, j.WriteCounts(), "all keys counted as written")
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	assert.Len(t, lines, 2, "one line per key")
+	assert.True(t, strings.HasPrefix(lines[0], `JWT_KEY_RSA_KEY="-----BEGIN PUBLIC KEY-----\n`), "escaped PEM value")
+
+	changed, err = j.WriteEnvFile(name)
+	assert.Nil(t, err, "err == nil")
+	assert.False(t, changed, "second write unchanged")
+	assert.Equal(t, WriteCounts{Unchanged: 2}, j.WriteCounts(), "all keys counted as unchanged")
+}
+
+func TestJWKS_EnvFile_unsupported(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the HMAC key is skipped like any other writer
+	b, err := j.EnvFile()
+	assert.Nil(t, err, "err == nil")
+	assert.Len(t, strings.Split(strings.TrimSpace(string(b)), "\n"), 2, "supported keys written")
+	assert.NotContains(t, string(b), "JWT_KEY_HMAC_KEY", "unsupported key skipped")
+
+	// the same options apply when writing the file
+	name := filepath.Join(t.TempDir(), "keys.env")
+	changed, err := j.WriteEnvFile(name, WithFileMode(0600))
+	assert.Nil(t, err, "err == nil")
+	assert.True(t, changed, "env file written")
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(name)
+		if assert.Nil(t, err, "err == nil") {
+			assert.Equal(t, fs.FileMode(0600), info.Mode().Perm(), "file mode applied")
+		}
+	}
+}
+
+func TestJWKS_EnvFile_options(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []WriteOption
+		want    int
+		wantErr error
+	}{
+		{name: "signing keys", opts: []WriteOption{WithUse("sig")}, want: 2},
+		{name: "encryption keys", opts: []WriteOption{WithUse("enc")}, want: 0},
+		{name: "strict", opts: []WriteOption{WithStrict()}, want: 2, wantErr: ErrUnsupportedAlgorithm},
+	}
+	for _, tt := range tests {
+		b, err := j.EnvFile(tt.opts...)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+		assert.Equal(t, tt.want, strings.Count(string(b), "JWT_KEY_"), tt.name+": keys written")
+	}
+}
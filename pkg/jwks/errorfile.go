@@ -0,0 +1,88 @@
+package jwks
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"strconv"
+)
+
+// KeyError describes a key that could not be converted or written. Keys
+// without a key ID are identified by their position in the JWKS as "#N".
+type KeyError struct {
+	KeyID  string `json:"kid"`
+	ALG    string `json:"alg"`
+	Reason string `json:"reason"`
+}
+
+// KeyErrors returns details of each key that failed in the error
+// returned by WriteKeys
+func (j *JWKS) KeyErrors(err error) []KeyError {
+	keyErrors := make([]KeyError, 0)
+	for _, we := range writeerrors(err) {
+		ke := KeyError{KeyID: we.KeyID, Reason: we.Message}
+		if we.Err != nil {
+			ke.Reason += ": " + we.Err.Error()
+		}
+
+		// look up the failed key
+		for n, jwk := range j.keyset {
+			if jwk == we.key || (we.key == nil && jwk.KID() == we.KeyID) {
+				ke.ALG = jwk.ALG()
+				if ke.KeyID == "" {
+					ke.KeyID = "#" + strconv.Itoa(n)
+				}
+				break
+			}
+		}
+
+		keyErrors = append(keyErrors, ke)
+	}
+
+	return keyErrors
+}
+
+// WriteErrorFile writes the details of each key that failed in the
+// error returned by WriteKeys as a JSON list to "name". The file is
+// removed if no keys failed.
+func (j *JWKS) WriteErrorFile(name string, err error) error {
+	keyErrors := j.KeyErrors(err)
+	if len(keyErrors) == 0 {
+		if err := os.Remove(name); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return &WriteError{Message: "removing error file failed", Err: err}
+		}
+
+		return nil
+	}
+
+	data, err := json.MarshalIndent(keyErrors, "", "  ")
+	if err != nil {
+		return &WriteError{Message: "could not encode error file", Err: err}
+	}
+
+	if err := writefile(name, "", data, new(writeOptions)); err != nil {
+		return &WriteError{Message: "writing error file failed", Err: err}
+	}
+
+	return nil
+}
+
+// writeerrors returns every *WriteError for a specific key within err
+func writeerrors(err error) []*WriteError {
+	switch e := err.(type) {
+	case interface{ Unwrap() []error }:
+		list := make([]*WriteError, 0)
+		for _, err := range e.Unwrap() {
+			list = append(list, writeerrors(err)...)
+		}
+
+		return list
+	case *WriteError:
+		if e.KeyID != "" || e.key != nil {
+			return []*WriteError{e}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package jwks
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKS_WriteErrorFile(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "errors.json")
+
+	// the unsupported key should be recorded
+	_, writeErr := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithStrict())
+	assert.Nil(t, j.WriteErrorFile(name, writeErr), "err == nil")
+
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []KeyError
+	assert.Nil(t, json.Unmarshal(b, &got), "error file is valid JSON")
+	if assert.Len(t, got, 1, "one failed key") {
+		assert.Equal(t, "hmac-key", got[0].KeyID, "kid of failed key")
+		assert.Equal(t, "HS256", got[0].ALG, "alg of failed key")
+		assert.NotEmpty(t, got[0].Reason, "reason for failure")
+	}
+
+	// a successful run removes the file
+	assert.Nil(t, j.WriteErrorFile(name, nil), "err == nil")
+	assert.NoFileExists(t, name, "error file removed")
+}
+
+func TestJWKS_KeyErrors(t *testing.T) {
+	// an unsupported key without a key ID after a supported key
+	j, err := parseJWKS([]byte(`{"keys": [
+		{"kty": "oct", "alg": "HS256", "kid": "hmac-key", "k": "c2VjcmV0"},
+		{"kty": "oct", "alg": "HS256", "k": "c2VjcmV0"}
+	]}`), new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, writeErr := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", t.TempDir(), WithStrict())
+
+	got := j.KeyErrors(writeErr)
+	if assert.Len(t, got, 2, "both failed keys") {
+		assert.Equal(t, "hmac-key", got[0].KeyID, "kid of failed key")
+		assert.Equal(t, "#1", got[1].KeyID, "position of failed key without a kid")
+		assert.Equal(t, "HS256", got[1].ALG, "alg of failed key without a kid")
+	}
+
+	// a key error without an underlying error
+	got = j.KeyErrors(&WriteError{Message: "invalid key", KeyID: "hmac-key"})
+	if assert.Len(t, got, 1, "one failed key") {
+		assert.Equal(t, "invalid key", got[0].Reason, "reason is the message")
+	}
+}
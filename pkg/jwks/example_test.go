@@ -0,0 +1,99 @@
+package jwks_test
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/andrewheberle/jwks-to-pem/pkg/jwks"
+)
+
+// server serves the test JWKS in place of an identity provider
+func server() *httptest.Server {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+}
+
+func ExampleGetJWKS() {
+	ts := server()
+	defer ts.Close()
+
+	j, err := jwks.GetJWKS(ts.URL, time.Second*5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, k := range j.Keys() {
+		fmt.Println(k.KID(), k.ALG())
+	}
+	// Output:
+	// rsa-key RS256
+	// ec-key ES256
+}
+
+func ExampleJWKS_WriteKeys() {
+	ts := server()
+	defer ts.Close()
+
+	j, err := jwks.GetJWKS(ts.URL, time.Second*5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dir, err := os.MkdirTemp("", "keys")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	changed, err := j.WriteKeys(context.Background(), "{{ .ALG }}-{{ .KeyID }}.pem", dir, jwks.WithFileMode(0600))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("changed:", changed)
+	fmt.Println("written:", j.ChangedKeys())
+
+	// writing the same keys again changes nothing
+	changed, err = j.WriteKeys(context.Background(), "{{ .ALG }}-{{ .KeyID }}.pem", dir, jwks.WithFileMode(0600))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println("changed:", changed)
+	// Output:
+	// changed: true
+	// written: [rsa-key ec-key]
+	// changed: false
+}
+
+func ExampleJWK_PEM() {
+	ts := server()
+	defer ts.Close()
+
+	j, err := jwks.GetJWKS(ts.URL, time.Second*5)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := j.Keys()[0].PEM()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	fmt.Println(strings.SplitN(string(data), "\n", 2)[0])
+	// Output:
+	// -----BEGIN PUBLIC KEY-----
+}
@@ -0,0 +1,607 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+)
+
+// DefaultMaxBodySize is the default limit on the size of a JWKS response
+const DefaultMaxBodySize = 4 << 20
+
+// DefaultMaxRedirects is the default number of redirects followed when
+// fetching a JWKS, matching the net/http client
+const DefaultMaxRedirects = 10
+
+// FetchOption configures how a JSON Web Key Set is retrieved by GetJWKS
+type FetchOption func(*fetchOptions)
+
+type fetchOptions struct {
+	lenient       bool
+	logTLS        bool
+	retries       int
+	retryInterval time.Duration
+	maxBodySize   int64
+	tokenFile     string
+	minTLSVersion uint16
+	maxIdleConns  int
+	idleTimeout   time.Duration
+	allowPrivate  bool
+	maxRedirects  int
+	crossHostAuth bool
+	headers       http.Header
+	certFile      string
+	keyFile       string
+	caFile        string
+	validators    *Validators
+	logger        *slog.Logger
+
+	// received holds the validators of the response until it is parsed
+	received Validators
+
+	// client is built from the options by GetJWKS
+	client *http.Client
+}
+
+// Validators holds the HTTP cache validators returned with a JWKS so that
+// a later fetch of the same URL can be made conditional
+type Validators struct {
+	URL          string
+	ETag         string
+	LastModified string
+}
+
+// statusError is returned when the JWKS URL responds with an unexpected
+// status code, along with any delay requested via Retry-After
+type statusError struct {
+	code       int
+	retryAfter time.Duration
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("%s: %d", ErrUnexpectedStatus, e.code)
+}
+
+func (e *statusError) Unwrap() error { return ErrUnexpectedStatus }
+
+// WithLenientParse allows a bare JSON array of keys to be accepted in
+// place of the standard {"keys": [...]} envelope
+func WithLenientParse() FetchOption {
+	return func(o *fetchOptions) {
+		o.lenient = true
+	}
+}
+
+// WithTLSLogging logs the certificate presented by the JWKS server at
+// info level rather than debug
+func WithTLSLogging() FetchOption {
+	return func(o *fetchOptions) {
+		o.logTLS = true
+	}
+}
+
+// WithRetries retries a failed fetch up to retries times after a network
+// error or a 5xx or 429 response. The wait between attempts starts at
+// interval and doubles each time unless the server requests a different
+// delay via a Retry-After header.
+func WithRetries(retries int, interval time.Duration) FetchOption {
+	return func(o *fetchOptions) {
+		o.retries = retries
+		o.retryInterval = interval
+	}
+}
+
+// WithMaxBodySize limits the size of the JWKS response to size bytes.
+// A size of zero or less disables the limit.
+func WithMaxBodySize(size int64) FetchOption {
+	return func(o *fetchOptions) {
+		o.maxBodySize = size
+	}
+}
+
+// WithHeaders adds headers to each request for the JWKS. An Authorization
+// header is replaced by the token when WithTokenFile is also used.
+func WithHeaders(headers http.Header) FetchOption {
+	return func(o *fetchOptions) {
+		o.headers = headers
+	}
+}
+
+// WithTokenFile sends the contents of the file at name as a bearer token
+// in the Authorization header. The file is read on every request so a
+// token that is rotated by another process is picked up.
+func WithTokenFile(name string) FetchOption {
+	return func(o *fetchOptions) {
+		o.tokenFile = name
+	}
+}
+
+// WithMinTLSVersion sets the minimum TLS version, such as
+// tls.VersionTLS13, accepted from the JWKS server. The default is
+// tls.VersionTLS12.
+func WithMinTLSVersion(version uint16) FetchOption {
+	return func(o *fetchOptions) {
+		o.minTLSVersion = version
+	}
+}
+
+// WithClientCertificate presents the certificate and key in the PEM files
+// certFile and keyFile to JWKS servers that require mutual TLS. The files
+// are read on each new connection so a renewed certificate is picked up.
+func WithClientCertificate(certFile, keyFile string) FetchOption {
+	return func(o *fetchOptions) {
+		o.certFile = certFile
+		o.keyFile = keyFile
+	}
+}
+
+// WithRootCAs verifies the JWKS server against the CA certificates in the
+// PEM file name rather than the system roots
+func WithRootCAs(name string) FetchOption {
+	return func(o *fetchOptions) {
+		o.caFile = name
+	}
+}
+
+// WithIdleConns sets the maximum number of idle keep-alive connections
+// and how long they are kept open, so that repeated fetches can reuse a
+// connection to the JWKS server. The defaults match http.DefaultTransport.
+func WithIdleConns(max int, timeout time.Duration) FetchOption {
+	return func(o *fetchOptions) {
+		o.maxIdleConns = max
+		o.idleTimeout = timeout
+	}
+}
+
+// WithPrivateKeys allows the JWKS to contain keys with private key
+// components, which are otherwise rejected with ErrPrivateKey
+func WithPrivateKeys() FetchOption {
+	return func(o *fetchOptions) {
+		o.allowPrivate = true
+	}
+}
+
+// WithMaxRedirects limits the number of redirects followed when fetching
+// the JWKS. A limit of zero or less disables redirects entirely.
+func WithMaxRedirects(max int) FetchOption {
+	return func(o *fetchOptions) {
+		o.maxRedirects = max
+	}
+}
+
+// WithCrossHostCredentials sends the headers of the original request,
+// including Authorization, again after a redirect to a different host.
+// Without it they are only sent again when the redirect stays on the
+// same host.
+func WithCrossHostCredentials() FetchOption {
+	return func(o *fetchOptions) {
+		o.crossHostAuth = true
+	}
+}
+
+// WithFetchLogger logs redirects, retries, the server certificate and the
+// warnings of GetJWKSFailover and GetJWKSMerged to logger rather than the
+// default logger of the slog package
+func WithFetchLogger(logger *slog.Logger) FetchOption {
+	return func(o *fetchOptions) {
+		o.logger = logger
+	}
+}
+
+// log returns the logger set by WithFetchLogger or the default logger
+func (o *fetchOptions) log() *slog.Logger {
+	if o.logger == nil {
+		return slog.Default()
+	}
+
+	return o.logger
+}
+
+// fetchlogger returns the logger set by WithFetchLogger in opts or the
+// default logger
+func fetchlogger(opts []FetchOption) *slog.Logger {
+	options := new(fetchOptions)
+	for _, o := range opts {
+		o(options)
+	}
+
+	return options.log()
+}
+
+// WithValidators makes the request conditional on the JWKS having changed
+// since v was recorded for the same URL, in which case GetJWKS returns
+// ErrNotModified. The validators of a successfully parsed response are
+// stored in v for the next fetch.
+func WithValidators(v *Validators) FetchOption {
+	return func(o *fetchOptions) {
+		o.validators = v
+	}
+}
+
+// transportKey identifies a transport that may be shared between fetches
+type transportKey struct {
+	minTLSVersion uint16
+	maxIdleConns  int
+	idleTimeout   time.Duration
+	certFile      string
+	keyFile       string
+	caFile        string
+}
+
+// transports holds the transport for each combination of options so
+// connections are reused across calls to GetJWKS
+var transports sync.Map
+
+// httpclient returns a client configured based on the options
+func (o *fetchOptions) httpclient() (*http.Client, error) {
+	key := transportKey{o.minTLSVersion, o.maxIdleConns, o.idleTimeout, o.certFile, o.keyFile, o.caFile}
+
+	transport, ok := transports.Load(key)
+	if !ok {
+		config, err := o.tlsconfig()
+		if err != nil {
+			return nil, err
+		}
+
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = config
+		t.MaxIdleConns = o.maxIdleConns
+		t.IdleConnTimeout = o.idleTimeout
+
+		transport, _ = transports.LoadOrStore(key, t)
+	}
+
+	return &http.Client{Transport: transport.(*http.Transport), CheckRedirect: o.checkredirect}, nil
+}
+
+// tlsconfig returns the TLS configuration based on the options
+func (o *fetchOptions) tlsconfig() (*tls.Config, error) {
+	config := &tls.Config{MinVersion: o.minTLSVersion}
+
+	if o.caFile != "" {
+		data, err := os.ReadFile(o.caFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read CA file: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificates found in CA file %s", o.caFile)
+		}
+		config.RootCAs = pool
+	}
+
+	if o.certFile != "" || o.keyFile != "" {
+		// fail early if the pair cannot be loaded
+		if _, err := tls.LoadX509KeyPair(o.certFile, o.keyFile); err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %w", err)
+		}
+
+		config.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cert, err := tls.LoadX509KeyPair(o.certFile, o.keyFile)
+			if err != nil {
+				return nil, fmt.Errorf("could not load client certificate: %w", err)
+			}
+
+			return &cert, nil
+		}
+	}
+
+	return config, nil
+}
+
+// checkredirect enforces the redirect limit and re-attaches the headers
+// from the original request, as net/http drops Authorization when a
+// redirect crosses hosts. Credentials only follow a redirect to another
+// host when WithCrossHostCredentials is used.
+func (o *fetchOptions) checkredirect(req *http.Request, via []*http.Request) error {
+	if len(via) > o.maxRedirects {
+		return fmt.Errorf("%w: limit is %d", ErrTooManyRedirects, o.maxRedirects)
+	}
+
+	if req.URL.Host == via[0].URL.Host || o.crossHostAuth {
+		for k, v := range via[0].Header {
+			if _, ok := req.Header[k]; !ok {
+				req.Header[k] = v
+			}
+		}
+	}
+
+	o.log().Info("following redirect for JWKS", "from", via[len(via)-1].URL.Redacted(), "to", req.URL.Redacted(), "status", req.Response.StatusCode)
+
+	return nil
+}
+
+// readtoken returns the bearer token from the file at name
+func readtoken(name string) (string, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("could not read token file: %w", err)
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return "", fmt.Errorf("token file %s is empty", name)
+	}
+
+	return token, nil
+}
+
+// localpath returns the file system path for a file:// URL or a plain
+// path, and false for any other URL
+func localpath(raw string) (string, bool) {
+	if !strings.HasPrefix(raw, "file://") {
+		return raw, !strings.Contains(raw, "://")
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+
+	// a windows path is given as file:///C:/path
+	path := u.Path
+	if runtime.GOOS == "windows" && len(path) > 2 && path[0] == '/' && path[2] == ':' {
+		path = path[1:]
+	}
+
+	return filepath.FromSlash(path), true
+}
+
+func fetchretry(ctx context.Context, url string, options *fetchOptions) ([]byte, error) {
+	// local files are read directly
+	if path, ok := localpath(url); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read JWKS file: %w", err)
+		}
+
+		return data, nil
+	}
+
+	for attempt := 0; ; attempt++ {
+		data, err := fetch(ctx, url, options)
+		if err == nil || attempt >= options.retries {
+			return data, err
+		}
+
+		// work out how long to wait
+		wait, ok := retrywait(err, attempt, options)
+		if !ok {
+			return nil, err
+		}
+
+		// give up now if the wait would exceed our deadline
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return nil, err
+		}
+
+		options.log().Debug("retrying fetch of JWKS", "url", url, "attempt", attempt+1, "wait", wait, "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// retrywait returns how long to wait before retrying after err and
+// whether a retry should be attempted at all
+func retrywait(err error, attempt int, options *fetchOptions) (time.Duration, bool) {
+	backoff := options.retryInterval << attempt
+
+	var se *statusError
+	if !errors.As(err, &se) {
+		// only network errors may recover
+		var ne net.Error
+		if errors.As(err, &ne) && !tlsversionerror(err) {
+			return backoff, true
+		}
+
+		return 0, false
+	}
+
+	if se.code == http.StatusTooManyRequests || se.code >= http.StatusInternalServerError {
+		if se.retryAfter > 0 {
+			return se.retryAfter, true
+		}
+
+		return backoff, true
+	}
+
+	return 0, false
+}
+
+// retryafter parses a Retry-After header in either delay-seconds or
+// HTTP-date form
+func retryafter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+
+	return 0
+}
+
+func fetch(ctx context.Context, url string, options *fetchOptions) ([]byte, error) {
+	// set up request
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build request: %w", err)
+	}
+
+	// add custom headers
+	for key, values := range options.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+
+	// add bearer token
+	if options.tokenFile != "" {
+		token, err := readtoken(options.tokenFile)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	// only ask for the JWKS if it has changed
+	if v := options.validators; v != nil && v.URL == url {
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	}
+
+	// do request
+	client := options.client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		if tlsversionerror(err) {
+			return nil, fmt.Errorf("error during request: server does not support %s or later: %w", tls.VersionName(options.minTLSVersion), err)
+		}
+
+		return nil, fmt.Errorf("error during request: %w", err)
+	}
+	defer res.Body.Close()
+
+	// log details of the presented certificate
+	level := slog.LevelDebug
+	if options.logTLS {
+		level = slog.LevelInfo
+	}
+	logtls(ctx, options.log(), res.TLS, level)
+
+	// check response
+	if res.StatusCode == http.StatusNotModified && options.validators != nil {
+		return nil, ErrNotModified
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, &statusError{code: res.StatusCode, retryAfter: retryafter(res.Header.Get("Retry-After"))}
+	}
+
+	options.received = Validators{URL: url, ETag: res.Header.Get("ETag"), LastModified: res.Header.Get("Last-Modified")}
+
+	// read body up to the limit
+	if options.maxBodySize <= 0 {
+		return io.ReadAll(res.Body)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, options.maxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > options.maxBodySize {
+		return nil, fmt.Errorf("%w: limit is %d bytes", ErrBodyTooLarge, options.maxBodySize)
+	}
+
+	return data, nil
+}
+
+func parseJWKS(data []byte, options *fetchOptions) (*JWKS, error) {
+	// wrap a bare array of keys in an envelope if allowed
+	if options.lenient {
+		if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+			data = append(append([]byte(`{"keys":`), trimmed...), '}')
+		}
+	}
+
+	var marshal jwkset.JWKSMarshal
+	if err := json.Unmarshal(data, &marshal); err != nil {
+		return nil, fmt.Errorf("could not decode JWKS: %w", err)
+	}
+
+	// refuse to handle private keys unless allowed
+	if !options.allowPrivate {
+		private := make([]string, 0)
+		for _, m := range marshal.Keys {
+			if hasprivate(m) {
+				private = append(private, m.KID)
+			}
+		}
+
+		if len(private) > 0 {
+			return nil, fmt.Errorf("%w: %s", ErrPrivateKey, strings.Join(private, ", "))
+		}
+	}
+
+	// keys that fail to parse are kept so the failure is reported per key
+	keyset := new(JWKS)
+	seen := make(map[string]bool)
+	for _, m := range marshal.Keys {
+		// only the first key with each id is kept so they never share a file
+		if m.KID != "" && seen[m.KID] {
+			options.log().Debug("dropping key with duplicate key ID", "kid", m.KID)
+			continue
+		}
+		seen[m.KID] = true
+
+		key, err := jwkset.NewJWKFromMarshal(m, jwkset.JWKMarshalOptions{Private: true}, jwkset.JWKValidateOptions{})
+		keyset.keyset = append(keyset.keyset, &JWK{key: key, marshal: m, err: err})
+	}
+
+	return keyset, nil
+}
+
+// hasprivate reports if the JWK contains any private key components
+func hasprivate(m jwkset.JWKMarshal) bool {
+	return m.D != "" || m.P != "" || m.Q != "" || m.DP != "" || m.DQ != "" || m.QI != "" || len(m.OTH) > 0
+}
+
+// tlsversionerror reports if err was caused by the server not supporting
+// the minimum TLS version
+func tlsversionerror(err error) bool {
+	// the tls package does not export these errors
+	msg := err.Error()
+
+	return strings.Contains(msg, "protocol version not supported") || strings.Contains(msg, "unsupported protocol version")
+}
+
+func logtls(ctx context.Context, logger *slog.Logger, state *tls.ConnectionState, level slog.Level) {
+	// nothing to log for plain http
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return
+	}
+
+	cert := state.PeerCertificates[0]
+	logger.Log(ctx, level, "JWKS server certificate",
+		"version", tls.VersionName(state.Version),
+		"subject", cert.Subject.String(),
+		"issuer", cert.Issuer.String(),
+		"san", cert.DNSNames,
+		"expiry", cert.NotAfter,
+	)
+}
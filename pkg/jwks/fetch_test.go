@@ -0,0 +1,708 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testServer(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+
+	ts := httptest.NewServer(handler)
+	t.Cleanup(ts.Close)
+
+	return ts
+}
+
+func testJWKSData(t *testing.T) []byte {
+	t.Helper()
+
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func Test_retryafter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+	}{
+		{name: "empty", value: "", want: 0},
+		{name: "seconds", value: "3", want: time.Second * 3},
+		{name: "negative", value: "-3", want: 0},
+		{name: "past date", value: "Wed, 21 Oct 2015 07:28:00 GMT", want: 0},
+		{name: "invalid", value: "soon", want: 0},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, retryafter(tt.value), tt.name+": tt.want == got")
+	}
+
+	// a date in the future should be close to the requested delay
+	got := retryafter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.InDelta(t, time.Minute, got, float64(time.Second*2), "future date")
+}
+
+func TestGetJWKS_retryAfter(t *testing.T) {
+	data := testJWKSData(t)
+
+	var calls atomic.Int32
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		w.Write(data)
+	})
+
+	// without retries the rate limit response is returned
+	_, err := GetJWKS(ts.URL, time.Second*5)
+	assert.ErrorIs(t, err, ErrUnexpectedStatus, "no retries")
+
+	// with retries the Retry-After delay is honoured
+	calls.Store(0)
+	start := time.Now()
+	got, err := GetJWKS(ts.URL, time.Second*5, WithRetries(1, time.Millisecond))
+	assert.Nil(t, err, "err == nil")
+	assert.GreaterOrEqual(t, time.Since(start), time.Second, "waited for Retry-After")
+	if err == nil {
+		assert.Len(t, got.keyset, 2, "keys fetched after retry")
+	}
+
+	// a Retry-After beyond the timeout fails immediately
+	calls.Store(0)
+	_, err = GetJWKS(ts.URL, time.Millisecond*500, WithRetries(1, time.Millisecond))
+	assert.ErrorIs(t, err, ErrUnexpectedStatus, "Retry-After exceeds timeout")
+}
+
+func TestGetJWKS_retries(t *testing.T) {
+	data := testJWKSData(t)
+
+	tests := []struct {
+		name      string
+		status    int
+		wantCalls int32
+		wantErr   bool
+	}{
+		{name: "server error", status: http.StatusInternalServerError, wantCalls: 3, wantErr: false},
+		{name: "bad gateway", status: http.StatusBadGateway, wantCalls: 3, wantErr: false},
+		{name: "not found", status: http.StatusNotFound, wantCalls: 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		// fail twice then succeed
+		var calls atomic.Int32
+		ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+			if calls.Add(1) <= 2 {
+				w.WriteHeader(tt.status)
+				return
+			}
+
+			w.Write(data)
+		})
+
+		got, err := GetJWKS(ts.URL, time.Second*5, WithRetries(3, time.Millisecond*10))
+		assert.Equal(t, tt.wantCalls, calls.Load(), tt.name+": calls")
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrUnexpectedStatus, tt.name+": errors.Is(err, ErrUnexpectedStatus)")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		if err == nil {
+			assert.Len(t, got.keyset, 2, tt.name+": keys fetched after retry")
+		}
+	}
+}
+
+func TestGetJWKS_retriesNetworkError(t *testing.T) {
+	data := testJWKSData(t)
+
+	// reserve a port with nothing listening yet
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	// start listening after the first attempt is refused
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		l, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		ts.Listener = l
+		ts.Start()
+	}()
+	t.Cleanup(ts.Close)
+
+	_, err = GetJWKS("http://"+addr, time.Second*5, WithRetries(5, time.Millisecond*50))
+	assert.Nil(t, err, "err == nil after connection refused")
+}
+
+func TestGetJWKSContext(t *testing.T) {
+	data := testJWKSData(t)
+
+	// never respond until the client gives up
+	slow := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+
+	j, err := GetJWKSContext(context.Background(), ts.URL)
+	assert.Nil(t, err, "err == nil")
+	assert.Equal(t, 2, j.Len(), "keys fetched")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+
+	start := time.Now()
+	_, err = GetJWKSContext(ctx, slow.URL)
+	assert.ErrorIs(t, err, context.Canceled, "errors.Is(err, context.Canceled)")
+	assert.Less(t, time.Since(start), time.Second*5, "fetch aborted when cancelled")
+
+	// cancelling the context applies to every URL
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(time.Millisecond*100, cancel)
+
+	start = time.Now()
+	_, err = GetJWKSFailoverContext(ctx, []string{slow.URL, slow.URL}, time.Second*5)
+	assert.ErrorIs(t, err, context.Canceled, "failover: errors.Is(err, context.Canceled)")
+	assert.Less(t, time.Since(start), time.Second*5, "failover: fetch aborted when cancelled")
+
+	_, err = GetJWKSMergedContext(ctx, []string{ts.URL}, time.Second*5)
+	assert.ErrorIs(t, err, context.Canceled, "merged: errors.Is(err, context.Canceled)")
+}
+
+func TestGetJWKS_maxBodySize(t *testing.T) {
+	data := testJWKSData(t)
+
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+
+	_, err := GetJWKS(ts.URL, time.Second*5, WithMaxBodySize(int64(len(data))))
+	assert.Nil(t, err, "body at limit")
+
+	_, err = GetJWKS(ts.URL, time.Second*5, WithMaxBodySize(int64(len(data)-1)))
+	assert.ErrorIs(t, err, ErrBodyTooLarge, "body over limit")
+}
+
+func TestGetJWKSFailover(t *testing.T) {
+	data := testJWKSData(t)
+
+	var downCalls, upCalls atomic.Int32
+	down := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		downCalls.Add(1)
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	up := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		upCalls.Add(1)
+		w.Write(data)
+	})
+
+	tests := []struct {
+		name     string
+		urls     []string
+		wantDown int32
+		wantUp   int32
+		wantErr  bool
+	}{
+		{name: "primary up", urls: []string{up.URL, down.URL}, wantDown: 0, wantUp: 1, wantErr: false},
+		{name: "primary down", urls: []string{down.URL, up.URL}, wantDown: 1, wantUp: 1, wantErr: false},
+		{name: "all down", urls: []string{down.URL, down.URL}, wantDown: 2, wantUp: 0, wantErr: true},
+		{name: "no urls", urls: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		downCalls.Store(0)
+		upCalls.Store(0)
+
+		got, err := GetJWKSFailover(tt.urls, time.Second*5)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+			assert.Len(t, got.keyset, 2, tt.name+": keys fetched")
+		}
+		assert.Equal(t, tt.wantDown, downCalls.Load(), tt.name+": calls to failing URL")
+		assert.Equal(t, tt.wantUp, upCalls.Load(), tt.name+": calls to working URL")
+	}
+}
+
+func TestGetJWKS_tokenFile(t *testing.T) {
+	data := testJWKSData(t)
+
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer second" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(data)
+	})
+
+	name := filepath.Join(t.TempDir(), "token")
+
+	_, err := GetJWKS(ts.URL, time.Second*5, WithTokenFile(name))
+	assert.ErrorIs(t, err, os.ErrNotExist, "missing token file")
+
+	// token is re-read on each fetch
+	for _, token := range []string{"first\n", "second\n"} {
+		if err := os.WriteFile(name, []byte(token), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = GetJWKS(ts.URL, time.Second*5, WithTokenFile(name))
+		if token == "second\n" {
+			assert.Nil(t, err, "rotated token accepted")
+		} else {
+			assert.ErrorIs(t, err, ErrUnexpectedStatus, "old token rejected")
+		}
+	}
+}
+
+func TestGetJWKSMerged(t *testing.T) {
+	okp, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-okp.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the second issuer also publishes a key with the same id as the first
+	var first, second struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(testJWKSData(t), &first); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(okp, &second); err != nil {
+		t.Fatal(err)
+	}
+	second.Keys = append(second.Keys, first.Keys[0])
+	data, err := json.Marshal(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testJWKSData(t))
+	})
+	b := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	down := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+
+	tests := []struct {
+		name     string
+		urls     []string
+		wantKIDs []string
+		wantErr  bool
+	}{
+		{name: "merged", urls: []string{a.URL, b.URL}, wantKIDs: []string{"rsa-key", "ec-key", "ed25519-key", "x25519-key", "ed448-key", "ed25519-noalg-key"}, wantErr: false},
+		{name: "one source down", urls: []string{a.URL, down.URL}, wantErr: true},
+		{name: "no urls", urls: nil, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := GetJWKSMerged(tt.urls, time.Second*5)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		kids := make([]string, 0)
+		for _, jwk := range got.keyset {
+			kids = append(kids, jwk.KID())
+		}
+		assert.Equal(t, tt.wantKIDs, kids, tt.name+": keys merged")
+
+		// keys from both issuers are written
+		dir := t.TempDir()
+		_, err = got.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir)
+		assert.Nil(t, err, tt.name+": unsupported curves skipped")
+		for _, name := range []string{"rsa-key.pem", "ec-key.pem", "ed25519-key.pem"} {
+			assert.FileExists(t, filepath.Join(dir, name), tt.name+": "+name+" written")
+		}
+	}
+}
+
+func TestGetJWKS_fetchLogger(t *testing.T) {
+	data := testJWKSData(t)
+
+	up := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+	down := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	moved := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, up.URL, http.StatusFound)
+	})
+
+	tests := []struct {
+		name  string
+		fetch func(...FetchOption) error
+		want  string
+	}{
+		{name: "redirect", fetch: func(opts ...FetchOption) error {
+			_, err := GetJWKS(moved.URL, time.Second*5, opts...)
+			return err
+		}, want: "following redirect for JWKS"},
+		{name: "failover", fetch: func(opts ...FetchOption) error {
+			_, err := GetJWKSFailover([]string{down.URL, up.URL}, time.Second*5, opts...)
+			return err
+		}, want: "fetch of JWKS failed, trying next URL"},
+		{name: "merged", fetch: func(opts ...FetchOption) error {
+			_, err := GetJWKSMerged([]string{up.URL, up.URL}, time.Second*5, opts...)
+			return err
+		}, want: "key ID found in more than one JWKS, keeping the first"},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		logger := slog.New(slog.NewTextHandler(buf, nil))
+
+		assert.Nil(t, tt.fetch(WithFetchLogger(logger)), tt.name+": err == nil")
+		assert.Contains(t, buf.String(), tt.want, tt.name+": logged to the fetch logger")
+	}
+}
+
+func TestGetJWKS_headers(t *testing.T) {
+	data := testJWKSData(t)
+
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" || r.Header.Get("X-Tenant") != "example" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(data)
+	})
+
+	tests := []struct {
+		name    string
+		headers http.Header
+		wantErr bool
+	}{
+		{name: "no headers", headers: nil, wantErr: true},
+		{name: "missing tenant", headers: http.Header{"Authorization": {"Bearer token"}}, wantErr: true},
+		{name: "all headers", headers: http.Header{"Authorization": {"Bearer token"}, "X-Tenant": {"example"}}, wantErr: false},
+	}
+	for _, tt := range tests {
+		_, err := GetJWKS(ts.URL, time.Second*5, WithHeaders(tt.headers))
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrUnexpectedStatus, tt.name+": errors.Is(err, ErrUnexpectedStatus)")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+	}
+}
+
+func TestGetJWKS_validators(t *testing.T) {
+	data := testJWKSData(t)
+	lastModified := "Mon, 02 Jan 2006 15:04:05 GMT"
+
+	var etag, ifNoneMatch, ifModifiedSince string
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		ifNoneMatch = r.Header.Get("If-None-Match")
+		ifModifiedSince = r.Header.Get("If-Modified-Since")
+		if ifNoneMatch == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", lastModified)
+		w.Write(data)
+	})
+
+	v := new(Validators)
+	tests := []struct {
+		name                string
+		etag                string
+		wantIfNoneMatch     string
+		wantIfModifiedSince string
+		wantErr             error
+	}{
+		{name: "first fetch", etag: `"v1"`, wantIfNoneMatch: "", wantIfModifiedSince: "", wantErr: nil},
+		{name: "unchanged", etag: `"v1"`, wantIfNoneMatch: `"v1"`, wantIfModifiedSince: lastModified, wantErr: ErrNotModified},
+		{name: "changed", etag: `"v2"`, wantIfNoneMatch: `"v1"`, wantIfModifiedSince: lastModified, wantErr: nil},
+		{name: "unchanged after change", etag: `"v2"`, wantIfNoneMatch: `"v2"`, wantIfModifiedSince: lastModified, wantErr: ErrNotModified},
+	}
+	for _, tt := range tests {
+		etag = tt.etag
+
+		j, err := GetJWKS(ts.URL, time.Second*5, WithValidators(v))
+		assert.Equal(t, tt.wantIfNoneMatch, ifNoneMatch, tt.name+": If-None-Match sent")
+		assert.Equal(t, tt.wantIfModifiedSince, ifModifiedSince, tt.name+": If-Modified-Since sent")
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+			assert.Nil(t, j, tt.name+": j == nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.etag, v.ETag, tt.name+": ETag recorded")
+	}
+
+	// validators for another URL are not sent
+	_, err := GetJWKS(ts.URL+"/other", time.Second*5, WithValidators(v))
+	assert.Nil(t, err, "other url: err == nil")
+	assert.Equal(t, "", ifNoneMatch, "other url: If-None-Match not sent")
+}
+
+func TestGetJWKS_minTLSVersion(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(testJWKSData(t))
+	}))
+	ts.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	_, err := GetJWKS(ts.URL, time.Second*5, WithMinTLSVersion(tls.VersionTLS13))
+	assert.ErrorContains(t, err, "server does not support TLS 1.3 or later", "old TLS version rejected")
+}
+
+func TestGetJWKS_idleConns(t *testing.T) {
+	data := testJWKSData(t)
+
+	var conns atomic.Int32
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	ts.Config.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			conns.Add(1)
+		}
+	}
+	ts.Start()
+	t.Cleanup(ts.Close)
+
+	tests := []struct {
+		name      string
+		opts      []FetchOption
+		wantConns int32
+	}{
+		{name: "keep-alive", opts: []FetchOption{WithIdleConns(10, time.Minute)}, wantConns: 1},
+		{name: "no idle connections", opts: []FetchOption{WithIdleConns(10, time.Nanosecond)}, wantConns: 3},
+	}
+	for _, tt := range tests {
+		conns.Store(0)
+
+		for range 3 {
+			_, err := GetJWKS(ts.URL, time.Second*5, tt.opts...)
+			assert.Nil(t, err, tt.name+": err == nil")
+			time.Sleep(time.Millisecond * 10)
+		}
+
+		assert.Equal(t, tt.wantConns, conns.Load(), tt.name+": connections opened")
+	}
+}
+
+func TestGetJWKS_maxRedirects(t *testing.T) {
+	data := testJWKSData(t)
+
+	target := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write(data)
+	})
+
+	// redirect to a different host so net/http would drop Authorization
+	location := strings.Replace(target.URL, "127.0.0.1", "localhost", 1)
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, location, http.StatusFound)
+	})
+
+	name := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(name, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// a redirect that stays on the same host
+	same := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, target.URL, http.StatusFound)
+	})
+
+	tests := []struct {
+		name      string
+		url       string
+		max       int
+		crossHost bool
+		wantErr   bool
+	}{
+		{name: "redirect followed", url: ts.URL, max: DefaultMaxRedirects, crossHost: true, wantErr: false},
+		{name: "credentials not sent to another host", url: ts.URL, max: DefaultMaxRedirects, wantErr: true},
+		{name: "credentials sent to same host", url: same.URL, max: DefaultMaxRedirects, wantErr: false},
+		{name: "redirects disabled", url: ts.URL, max: 0, crossHost: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		opts := []FetchOption{WithTokenFile(name), WithMaxRedirects(tt.max)}
+		if tt.crossHost {
+			opts = append(opts, WithCrossHostCredentials())
+		}
+
+		got, err := GetJWKS(tt.url, time.Second*5, opts...)
+		if tt.wantErr {
+			if tt.max == 0 {
+				assert.ErrorIs(t, err, ErrTooManyRedirects, tt.name+": errors.Is(err, ErrTooManyRedirects)")
+			} else {
+				assert.NotNil(t, err, tt.name+": err != nil")
+			}
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		if err == nil {
+			assert.Len(t, got.keyset, 2, tt.name+": keys fetched")
+		}
+	}
+}
+
+func TestGetJWKS_local(t *testing.T) {
+	data := testJWKSData(t)
+
+	ts := testServer(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	})
+
+	want, err := GetJWKS(ts.URL, time.Second*5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := filepath.Abs(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		url     string
+		wantErr bool
+	}{
+		{name: "plain path", url: path, wantErr: false},
+		{name: "file url", url: (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String(), wantErr: false},
+		{name: "missing file", url: filepath.Join(t.TempDir(), "missing.json"), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := GetJWKS(tt.url, time.Second*5)
+		if tt.wantErr {
+			assert.ErrorIs(t, err, os.ErrNotExist, tt.name+": errors.Is(err, os.ErrNotExist)")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		if err == nil {
+			assert.Equal(t, want.Summary(), got.Summary(), tt.name+": same keys as HTTP")
+		}
+	}
+}
+
+func TestGetJWKS_clientCertificate(t *testing.T) {
+	data := testJWKSData(t)
+	dir := t.TempDir()
+
+	// self-signed client certificate trusted by the server
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "client"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(data)
+	}))
+	ts.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	// pin the test server certificate
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []FetchOption
+		wantErr bool
+	}{
+		{name: "untrusted server", opts: nil, wantErr: true},
+		{name: "no client certificate", opts: []FetchOption{WithRootCAs(caFile)}, wantErr: true},
+		{name: "client certificate", opts: []FetchOption{WithRootCAs(caFile), WithClientCertificate(certFile, keyFile)}, wantErr: false},
+		{name: "missing key", opts: []FetchOption{WithRootCAs(caFile), WithClientCertificate(certFile, "")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := GetJWKS(ts.URL, time.Second*5, tt.opts...)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+	}
+}
@@ -1,34 +1,130 @@
+// Package jwks fetches JSON Web Key Sets and converts their public keys to
+// PEM and other formats.
+//
+// A set is retrieved with GetJWKS, or GetJWKSFailover and GetJWKSMerged for
+// several URLs, and its keys are written to files named by a template with
+// WriteKeys. Each key is also available individually as a JWK, for example
+// to PEM encode it in memory with PEM. The behaviour of fetching and writing
+// is controlled by FetchOption and WriteOption values.
 package jwks
 
 import (
 	"bytes"
 	"context"
+	"crypto"
 	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
+	"fmt"
 	"html/template"
 	"io"
 	"io/fs"
+	"net/http"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/MicahParks/jwkset"
+	"golang.org/x/crypto/ssh"
+)
+
+// Output formats supported by Encode
+const (
+	FormatPEM = "pem"
+	FormatDER = "der"
+	FormatJWK = "jwk"
+	FormatSSH = "ssh"
+)
+
+// Encodings of the public key inside a PEM block supported by PEMAs
+const (
+	// PEMTypePKIX encodes the key as a PKIX "PUBLIC KEY" block
+	PEMTypePKIX = "pkix"
+
+	// PEMTypePKCS1 encodes an RSA key as a PKCS#1 "RSA PUBLIC KEY" block
+	PEMTypePKCS1 = "pkcs1"
 )
 
 // JWKS represents a JSON Web Key Set
 type JWKS struct {
-	keyset []*JWK
+	keyset   []*JWK
+	url      string
+	changes  Changes
+	counts   WriteCounts
+	manifest []ManifestEntry
+}
+
+// Changes describes what the last call to WriteKeys or WriteBundle did on
+// disk
+type Changes struct {
+	// Added is the key ids of keys written to a new file
+	Added []string
+
+	// Changed is the key ids of keys whose existing file was rewritten
+	Changed []string
+
+	// Removed is the paths of files removed by WithPrune, which is never
+	// set by WriteBundle
+	Removed []string
+}
+
+// WriteCounts is the number of keys handled each way by the last call to
+// WriteKeys, WriteBundle, EnvFile or WriteEnvFile
+type WriteCounts struct {
+	// Written is the number of keys written to disk or stdout
+	Written int
+
+	// Unchanged is the number of keys whose existing file was up to date
+	Unchanged int
+
+	// Skipped is the number of keys of an unsupported type or algorithm
+	Skipped int
+
+	// Errored is the number of keys that could not be written
+	Errored int
 }
 
+// PatternData is the data available to the naming pattern for each key
+type PatternData struct {
+	// Index is the position of the key in the JWKS, or its slot when
+	// using WithSlots
+	Index int
+
+	// KeyID is the key ID, its hash when using WithKIDHash or the Index
+	// if the key has no ID
+	KeyID string
+
+	// ALG is the algorithm of the key as renamed by WithAlgorithmMap
+	ALG string
+
+	// KTY is the key type, such as "RSA", "EC" or "OKP"
+	KTY string
+
+	// Use is the intended use of the key, such as "sig" or "enc"
+	Use string
+
+	// Thumbprint is the unpadded base64url encoded SHA-256 hash of the
+	// DER encoded public key
+	Thumbprint string
+}
+
+// JWK is a single key of a JWKS
 type JWK struct {
-	key  jwkset.JWK
-	data []byte
-	mu   sync.Mutex
+	key     jwkset.JWK
+	marshal jwkset.JWKMarshal
+	err     error
+	data    []byte
+	mu      sync.Mutex
 }
 
 var (
@@ -37,9 +133,13 @@ var (
 	ErrPatternNotParsed = errors.New("pattern could not be parsed")
 
 	// ErrUnsupportedAlgorithm is returned when the JWKS contains a key
-	// type that is not RSA or ECDSA
+	// type that is not RSA, ECDSA or Ed25519
 	ErrUnsupportedAlgorithm = errors.New("unsupported key algorithm")
 
+	// ErrUnsupportedCurve is returned when an OKP key uses a curve
+	// other than Ed25519, such as the key agreement curve X25519
+	ErrUnsupportedCurve = errors.New("unsupported curve")
+
 	// ErrNotRSAPublicKey is returned when the key could not be
 	// converted to a *rsa.PublicKey despite the JWK specifying the
 	// algorithm as RS256, RS384 or RS512
@@ -50,9 +150,34 @@ var (
 	// algorithm as ES256, ES384 or ES512
 	ErrNotECDSAPublicKey = errors.New("was not a ECDSA public key")
 
+	// ErrNotEd25519PublicKey is returned when the key could not be
+	// converted to a ed25519.PublicKey despite the JWK specifying the
+	// algorithm as EdDSA
+	ErrNotEd25519PublicKey = errors.New("was not a Ed25519 public key")
+
+	// ErrX5CMismatch is returned when the public key of the leaf
+	// certificate in the x5c chain differs from the key of the JWK
+	ErrX5CMismatch = errors.New("x5c certificate does not match key")
+
+	// ErrUnsupportedFormat is returned when a key is requested in an
+	// unknown output format
+	ErrUnsupportedFormat = errors.New("unsupported format")
+
+	// ErrUnsupportedPEMType is returned when a key is requested in an
+	// unknown PEM encoding
+	ErrUnsupportedPEMType = errors.New("unsupported PEM type")
+
+	// ErrPKCS1NotRSA is returned when a key other than an RSA key is
+	// requested in PKCS#1 encoding
+	ErrPKCS1NotRSA = errors.New("pkcs1 encoding is only supported for RSA keys")
+
+	// ErrUnsafePath is returned when the file name produced by the
+	// pattern for a key would be outside of the output directory
+	ErrUnsafePath = errors.New("file name is outside of output directory")
+
 	// ErrPEMEncodeFailed is returned when the public key could not
 	// be encoded into PEM format.
-	ErrPEMEncodeFailed = errors.New("was not a RSA public key")
+	ErrPEMEncodeFailed = errors.New("could not PEM encode public key")
 
 	// ErrWriteFailed is returned when the public key could not
 	// written.
@@ -61,53 +186,236 @@ var (
 	// ErrTemplateProblem is returned when the key filename
 	// pattern could not be templated correctly.
 	ErrTemplateProblem = errors.New("problem executing template")
+
+	// ErrVerifyFailed is returned when the verification command
+	// returned a non-zero exit status for a key.
+	ErrVerifyFailed = errors.New("key verification failed")
+
+	// ErrBodyTooLarge is returned when the JWKS response exceeds the
+	// maximum allowed size.
+	ErrBodyTooLarge = errors.New("response body too large")
+
+	// ErrNoURL is returned when no JWKS URL was provided.
+	ErrNoURL = errors.New("no JWKS URL provided")
+
+	// ErrNoPEMBlock is returned when an existing key could not be
+	// decoded as PEM.
+	ErrNoPEMBlock = errors.New("no PEM block found")
+
+	// ErrUnexpectedStatus is returned when the JWKS URL responds with
+	// a HTTP status code other than 200 OK.
+	ErrUnexpectedStatus = errors.New("unexpected response status")
+
+	// ErrTooManyRedirects is returned when fetching the JWKS exceeds the
+	// redirect limit.
+	ErrTooManyRedirects = errors.New("too many redirects")
+
+	// ErrNotModified is returned when a conditional fetch of the JWKS
+	// finds that it has not changed.
+	ErrNotModified = errors.New("JWKS not modified")
+
+	// ErrPrivateKey is returned when the JWKS contains private key
+	// material and private keys have not been allowed.
+	ErrPrivateKey = errors.New("JWKS contains private key material")
+
+	// ErrInvalidToken is returned when a JWT could not be decoded.
+	ErrInvalidToken = errors.New("invalid token")
+
+	// ErrKeyNotFound is returned when no key in the JWKS matches the
+	// key ID of a JWT.
+	ErrKeyNotFound = errors.New("no matching key found")
+
+	// ErrInvalidSignature is returned when the signature of a JWT could
+	// not be verified by the matching key.
+	ErrInvalidSignature = errors.New("signature verification failed")
+
+	// ErrNoOutputDir is returned when the output directory does not exist
+	// and has not been allowed to be created.
+	ErrNoOutputDir = errors.New("output directory does not exist")
 )
 
+// WriteError is returned when a key could not be converted or written,
+// holding the ID of the key concerned
 type WriteError struct {
 	Message string
 	KeyID   string
 	Err     error
+
+	// key is the key that failed, which is set even if it has no key ID
+	key *JWK
 }
 
+// Error formats the message with the key ID and underlying error
 func (e *WriteError) Error() string {
+	msg := e.Message
 	if e.KeyID != "" {
-		return e.Message + "(KID: " + e.KeyID + "): " + e.Err.Error()
+		msg += " (KID: " + e.KeyID + ")"
+	}
+
+	if e.Err == nil {
+		return msg
 	}
 
-	return e.Message + ": " + e.Err.Error()
+	return msg + ": " + e.Err.Error()
 }
 
+// Unwrap returns the underlying error so errors.Is can match the sentinel
+// errors of this package
 func (e *WriteError) Unwrap() error { return e.Err }
 
-// GetJWKS fetches a JSON Web Key Set from the provided URL
-func GetJWKS(url string, timeout time.Duration) (*JWKS, error) {
-	// set up http client to grab jwks
-	j, err := jwkset.NewDefaultHTTPClient([]string{url})
+// GetJWKS fetches a JSON Web Key Set from the provided URL, giving up
+// after timeout
+func GetJWKS(url string, timeout time.Duration, opts ...FetchOption) (*JWKS, error) {
+	return getjwks(context.Background(), url, timeout, opts...)
+}
+
+// getjwks fetches a JSON Web Key Set giving up after timeout or when ctx
+// is cancelled
+func getjwks(ctx context.Context, url string, timeout time.Duration, opts ...FetchOption) (*JWKS, error) {
+	// only wait for timeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return GetJWKSContext(ctx, url, opts...)
+}
+
+// GetJWKSContext fetches a JSON Web Key Set from the provided URL, giving
+// up when ctx is cancelled or its deadline passes
+func GetJWKSContext(ctx context.Context, url string, opts ...FetchOption) (*JWKS, error) {
+	// apply options
+	defaults := http.DefaultTransport.(*http.Transport)
+	options := &fetchOptions{
+		maxBodySize:   DefaultMaxBodySize,
+		minTLSVersion: tls.VersionTLS12,
+		maxIdleConns:  defaults.MaxIdleConns,
+		idleTimeout:   defaults.IdleConnTimeout,
+		maxRedirects:  DefaultMaxRedirects,
+	}
+	for _, o := range opts {
+		o(options)
+	}
+	client, err := options.httpclient()
 	if err != nil {
 		return nil, err
 	}
+	options.client = client
 
-	// only wait for timeout
-	ctx, cancel := context.WithTimeout(context.Background(), timeout)
-	defer cancel()
+	// fetch raw jwks
+	data, err := fetchretry(ctx, url, options)
+	if err != nil {
+		return nil, err
+	}
 
-	// read keys from jwks
-	keys, err := j.KeyReadAll(ctx)
+	keyset, err := parseJWKS(data, options)
 	if err != nil {
 		return nil, err
 	}
+	keyset.url = url
 
-	keyset := new(JWKS)
-	for _, key := range keys {
-		keyset.keyset = append(keyset.keyset, &JWK{key: key})
+	// only remember the validators of a usable response
+	if options.validators != nil {
+		*options.validators = options.received
 	}
 
 	return keyset, nil
 }
 
-func (j *JWKS) WriteKeys(pattern, output string) (bool, error) {
+// GetJWKSFailover fetches a JSON Web Key Set from the first of the
+// provided URLs that can be retrieved successfully
+func GetJWKSFailover(urls []string, timeout time.Duration, opts ...FetchOption) (*JWKS, error) {
+	return GetJWKSFailoverContext(context.Background(), urls, timeout, opts...)
+}
+
+// GetJWKSFailoverContext is GetJWKSFailover giving up when ctx is
+// cancelled, with timeout applying to the fetch of each URL
+func GetJWKSFailoverContext(ctx context.Context, urls []string, timeout time.Duration, opts ...FetchOption) (*JWKS, error) {
+	if len(urls) == 0 {
+		return nil, ErrNoURL
+	}
+
+	logger := fetchlogger(opts)
+	errs := make([]error, 0, len(urls))
+	for n, url := range urls {
+		j, err := getjwks(ctx, url, timeout, opts...)
+		if err == nil || errors.Is(err, ErrNotModified) {
+			return j, err
+		}
+
+		errs = append(errs, fmt.Errorf("%s: %w", url, err))
+
+		// let them know we are trying the next url
+		if n < len(urls)-1 {
+			logger.Warn("fetch of JWKS failed, trying next URL", "url", url, "error", err)
+		}
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// GetJWKSMerged fetches a JSON Web Key Set from every one of the provided
+// URLs and merges their keys into one set. When the same key ID appears in
+// more than one set the key from the earliest URL is kept. Fetches are
+// never conditional as the merged set may change even if one source does
+// not.
+func GetJWKSMerged(urls []string, timeout time.Duration, opts ...FetchOption) (*JWKS, error) {
+	return GetJWKSMergedContext(context.Background(), urls, timeout, opts...)
+}
+
+// GetJWKSMergedContext is GetJWKSMerged giving up when ctx is cancelled,
+// with timeout applying to the fetch of each URL
+func GetJWKSMergedContext(ctx context.Context, urls []string, timeout time.Duration, opts ...FetchOption) (*JWKS, error) {
+	if len(urls) == 0 {
+		return nil, ErrNoURL
+	}
+
+	// every source must be fetched in full
+	opts = append(opts, WithValidators(nil))
+
+	logger := fetchlogger(opts)
+	merged := &JWKS{url: strings.Join(urls, ", ")}
+	seen := make(map[string]string)
+	errs := make([]error, 0)
+	for _, url := range urls {
+		j, err := getjwks(ctx, url, timeout, opts...)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			continue
+		}
+
+		for _, jwk := range j.keyset {
+			kid := jwk.KID()
+			if first, ok := seen[kid]; ok && kid != "" {
+				logger.Warn("key ID found in more than one JWKS, keeping the first", "kid", kid, "kept", first, "dropped", url)
+				continue
+			}
+
+			seen[kid] = url
+			merged.keyset = append(merged.keyset, jwk)
+		}
+	}
+
+	// a partial set of keys could remove keys that are still in use
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return merged, nil
+}
+
+// WriteKeys writes each key of the JWKS to a file in output named by the
+// Go template pattern, which is passed a PatternData for each key. Keys are
+// printed to stdout if output is empty. It reports whether any file was
+// changed, so callers can decide whether consumers need to be reloaded.
+func (j *JWKS) WriteKeys(ctx context.Context, pattern, output string, opts ...WriteOption) (bool, error) {
 	var err error
 	var keyChanged bool
+	var migrating bool
+
+	// apply options
+	options := &writeOptions{ctx: ctx}
+	for _, o := range opts {
+		o(options)
+	}
 
 	// set up template
 	t, err := template.New("pattern").Parse(pattern)
@@ -115,107 +423,467 @@ func (j *JWKS) WriteKeys(pattern, output string) (bool, error) {
 		return keyChanged, &WriteError{Message: "pattern could not be parsed", Err: err}
 	}
 
+	// set up any key type specific templates
+	templates := make(map[string]*template.Template)
+	for kty, p := range options.patterns {
+		kt, err := template.New("pattern-" + kty).Parse(p)
+		if err != nil {
+			return keyChanged, &WriteError{Message: "pattern for " + kty + " keys could not be parsed", Err: err}
+		}
+
+		templates[kty] = kt
+	}
+
+	// make sure the output directory exists before writing any keys
+	if output != "" && !options.dryRun {
+		if err := outputdir(output, options.mkdir); err != nil {
+			return keyChanged, err
+		}
+	}
+
+	// look up owner of output directory
+	if options.matchDirOwner && output != "" {
+		uid, gid, err := dirowner(output)
+		if errors.Is(err, errors.ErrUnsupported) {
+			options.log().Warn("matching the owner of the output directory is not supported on this platform")
+		} else if err != nil {
+			return keyChanged, &WriteError{Message: "could not determine owner of output directory", Err: err}
+		} else {
+			options.owner = &fileowner{uid, gid}
+		}
+	}
+
 	// keep track of errors
 	errs := make([]error, 0)
+	failed := false
+
+	// keep track of files for pruning
+	written := make(map[string]bool)
+
+	// number of keys written to stdout
+	printed := 0
+
+	// reset list of changed keys
+	j.changes = Changes{}
+	j.counts = WriteCounts{}
+	j.manifest = nil
+
+	// only write keys for the requested use
+	keys := filteruse(j.keyset, options.use)
+
+	// only write the newest keys when using slots
+	if options.slots > 0 {
+		keys = newest(keys, options.slots)
+	}
+
+	// write nothing unless every key can be converted
+	if options.allOrNothing {
+		if err := validateall(keys, options); err != nil {
+			j.counts.Errored = len(keys)
+			return keyChanged, err
+		}
+	}
 
 	// iterate over keys
-	for n, jwk := range j.keyset {
+	for n, jwk := range keys {
+		// stop if cancelled, leaving any remaining keys as they are
+		if err := ctx.Err(); err != nil {
+			errs = append(errs, &WriteError{Message: "writing keys was cancelled", Err: err})
+			failed = true
+			j.counts.Errored += len(keys) - n
+			break
+		}
+
 		// grab key id
 		keyID := jwk.KID()
 
-		data, err := jwk.PEM()
+		data, err := jwk.encode(options)
 		if err != nil {
-			errs = append(errs, err)
+			if unsupported(err) {
+				j.counts.Skipped++
+			} else {
+				// the key may still own a file that must not be pruned
+				failed = true
+				j.counts.Errored++
+			}
+			if !options.skip(jwk, err) {
+				errs = append(errs, options.keyerror(jwk, err))
+			}
 			continue
 		}
 
 		// write to stdout if no output is provided
 		if output == "" {
-			os.Stderr.Write(data)
+			// separate each key with a blank line unless binary
+			if printed > 0 && options.format != FormatDER {
+				os.Stdout.Write([]byte("\n"))
+			}
+			os.Stdout.Write(data)
+			printed++
+			j.counts.Written++
 			continue
 		}
 
+		// use key type specific template if set
+		kt, ok := templates[jwk.KTY()]
+		if !ok {
+			kt = t
+		}
+
 		// execute template as string
 		name := new(bytes.Buffer)
-		if err := t.Execute(name, struct {
-			Index int
-			KeyID string
-		}{
-			Index: n,
-			KeyID: keyID,
-		}); err != nil {
-			errs = append(errs, &WriteError{Message: "template execution failed", KeyID: keyID, Err: err})
+		if err := kt.Execute(name, options.patterndata(n, jwk)); err != nil {
+			errs = append(errs, options.keyerror(jwk, &WriteError{Message: "template execution failed", KeyID: keyID, Err: err}))
+			failed = true
+			j.counts.Errored++
+			continue
+		}
+
+		// never write outside the output directory
+		if !filepath.IsLocal(name.String()) {
+			errs = append(errs, options.keyerror(jwk, &WriteError{Message: "invalid file name", KeyID: keyID, Err: fmt.Errorf("%w: %s", ErrUnsafePath, name)}))
+			failed = true
+			j.counts.Errored++
 			continue
 		}
 
 		// build output file
-		outFile := filepath.Join(output, name.String())
+		dir := options.splitdir(output, jwk.ALG())
+		if dir != output && !options.dryRun {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				errs = append(errs, options.keyerror(jwk, &WriteError{Message: "could not create directory", KeyID: keyID, Err: err}))
+				failed = true
+				j.counts.Errored++
+				continue
+			}
+		}
+		outFile := filepath.Join(dir, name.String())
+		written[outFile] = true
 
 		// check if any changes have occurred
-		if changed, err := jwk.Changed(outFile); err != nil {
-			errs = append(errs, &WriteError{Message: "error comparing keys", KeyID: keyID, Err: err})
+		changed := keychanged
+		if options.semanticCompare && options.pem() && !options.preferX5C {
+			changed = semanticchanged
+		}
+		if changed, err := changed(outFile, data); err != nil {
+			errs = append(errs, options.keyerror(jwk, &WriteError{Message: "error comparing keys", KeyID: keyID, Err: err}))
+			failed = true
+			j.counts.Errored++
 			continue
 		} else if !changed {
+			if options.dryRun {
+				options.log().Info("dry run: would skip unchanged key", "kid", keyID, "alg", jwk.ALG(), "path", outFile)
+			} else {
+				options.log().Debug("skipping unchanged key", "kid", keyID, "alg", jwk.ALG(), "path", outFile)
+			}
+			j.counts.Unchanged++
+			j.manifest = append(j.manifest, options.manifestentry(jwk, outFile, false))
 			continue
 		}
 
+		// log once per run if existing keys are being rewritten in a new format
+		if !migrating {
+			if migrated, err := formatchanged(outFile, data); err == nil && migrated {
+				options.log().Info("existing keys are in a different format and will be rewritten", "format", formatof(data))
+				migrating = true
+			}
+		}
+
+		// note if this is a new key before it is written
+		_, statErr := os.Lstat(outFile)
+		added := errors.Is(statErr, fs.ErrNotExist)
+
+		// create any directories from the pattern
+		if options.mkdir && !options.dryRun {
+			if err := os.MkdirAll(filepath.Dir(outFile), 0755); err != nil {
+				errs = append(errs, options.keyerror(jwk, &WriteError{Message: "could not create directory", KeyID: keyID, Err: err}))
+				failed = true
+				j.counts.Errored++
+				continue
+			}
+		}
+
 		// write out pem encoded file
-		if err := jwk.Write(outFile); err != nil {
-			errs = append(errs, &WriteError{Message: "writing key failed", KeyID: keyID, Err: err})
+		if options.dryRun {
+			options.log().Info("dry run: would write key", "kid", keyID, "alg", jwk.ALG(), "path", outFile, "new", added)
+		} else if err := writefile(outFile, keyID, data, options); err != nil {
+			errs = append(errs, options.keyerror(jwk, &WriteError{Message: "writing key failed", KeyID: keyID, Err: err}))
+			failed = true
+			j.counts.Errored++
 			continue
 		}
 
+		if !options.dryRun {
+			options.log().Debug("wrote key", "kid", keyID, "alg", jwk.ALG(), "path", outFile, "new", added)
+		}
+
 		// on successful write set keyChanged to "true"
 		keyChanged = true
+		j.counts.Written++
+		j.manifest = append(j.manifest, options.manifestentry(jwk, outFile, true))
+		if added {
+			j.changes.Added = append(j.changes.Added, keyID)
+		} else {
+			j.changes.Changed = append(j.changes.Changed, keyID)
+		}
+	}
+
+	// remove files of keys no longer in the JWKS, unless a key that may
+	// own one of them could not be written
+	if options.prune && output != "" && !failed {
+		pruned, err := options.prunefiles(output, t, templates, written)
+		if err != nil {
+			errs = append(errs, &WriteError{Message: "pruning keys failed", Err: err})
+		}
+		if len(pruned) > 0 {
+			keyChanged = true
+			j.changes.Removed = pruned
+		}
 	}
 
 	// return any errors
 	return keyChanged, errors.Join(errs...)
 }
 
+// URL returns the URL the JWKS was fetched from
+func (j *JWKS) URL() string {
+	return j.url
+}
+
+// ChangedKeys returns the key ids of the keys written by the last call to
+// WriteKeys or WriteBundle, being the added keys followed by the changed
+// keys of Changes
+func (j *JWKS) ChangedKeys() []string {
+	return slices.Concat(j.changes.Added, j.changes.Changed)
+}
+
+// Changes returns the keys added, changed and removed by the last call to
+// WriteKeys or WriteBundle
+func (j *JWKS) Changes() Changes {
+	return j.changes
+}
+
+// WriteCounts returns the number of keys written, unchanged, skipped and
+// errored by the last call to WriteKeys, WriteBundle, EnvFile or
+// WriteEnvFile
+func (j *JWKS) WriteCounts() WriteCounts {
+	return j.counts
+}
+
+// Len returns the number of keys in the JWKS
+func (j *JWKS) Len() int {
+	return len(j.keyset)
+}
+
+// Keys returns the keys of the JWKS in the order they appear
+func (j *JWKS) Keys() []*JWK {
+	return slices.Clone(j.keyset)
+}
+
+// Supported returns a JWKS containing only the keys that can be
+// converted to PEM format
+func (j *JWKS) Supported() *JWKS {
+	keyset := new(JWKS)
+	for _, jwk := range j.keyset {
+		if _, err := jwk.PEM(); err != nil {
+			continue
+		}
+
+		keyset.keyset = append(keyset.keyset, jwk)
+	}
+
+	return keyset
+}
+
+// JSON returns the public keys of the JWKS as JWKS formatted JSON
+func (j *JWKS) JSON() ([]byte, error) {
+	marshal := jwkset.JWKSMarshal{Keys: make([]jwkset.JWKMarshal, 0, len(j.keyset))}
+	for _, jwk := range j.keyset {
+		// strip any private key material
+		m := jwk.marshal
+		m.D, m.P, m.Q, m.DP, m.DQ, m.QI, m.OTH = "", "", "", "", "", "", nil
+
+		marshal.Keys = append(marshal.Keys, m)
+	}
+
+	return json.MarshalIndent(marshal, "", "  ")
+}
+
+// WriteJWKS writes the public keys of the JWKS as JWKS formatted JSON
+// to "name" if it has changed
+func (j *JWKS) WriteJWKS(name string) (bool, error) {
+	data, err := j.JSON()
+	if err != nil {
+		return false, &WriteError{Message: "could not encode JWKS", Err: err}
+	}
+
+	// check if any changes have occurred
+	if changed, err := keychanged(name, data); err != nil {
+		return false, &WriteError{Message: "error comparing JWKS", Err: err}
+	} else if !changed {
+		return false, nil
+	}
+
+	if err := writefile(name, "", data, new(writeOptions)); err != nil {
+		return false, &WriteError{Message: "writing JWKS failed", Err: err}
+	}
+
+	return true, nil
+}
+
+// ALG returns the algorithm of the key, such as "RS256"
 func (k *JWK) ALG() string {
-	return k.key.Marshal().ALG.String()
+	return k.marshal.ALG.String()
+}
+
+// KTY returns the key type, such as "RSA"
+func (k *JWK) KTY() string {
+	return k.marshal.KTY.String()
+}
+
+// USE returns the intended use of the key, such as "sig"
+func (k *JWK) USE() string {
+	return k.marshal.USE.String()
 }
 
+// KID returns the key ID
 func (k *JWK) KID() string {
-	return k.key.Marshal().KID
+	return k.marshal.KID
 }
 
-func (jwk *JWK) Bytes() ([]byte, error) {
-	// take an exclusive lock at this time in case we alter things
-	jwk.mu.Lock()
-	defer jwk.mu.Unlock()
+// NotAfter returns the expiry of the leaf certificate in the x5c chain
+// of the JWK, if it has one
+func (k *JWK) NotAfter() (time.Time, bool) {
+	cert := k.certificate()
+	if cert == nil {
+		return time.Time{}, false
+	}
 
-	// check if this is already done
-	if jwk.data != nil {
-		return jwk.data, nil
+	return cert.NotAfter, true
+}
+
+// NotBefore returns the start of the validity period of the leaf
+// certificate in the x5c chain of the JWK, if it has one
+func (k *JWK) NotBefore() (time.Time, bool) {
+	cert := k.certificate()
+	if cert == nil {
+		return time.Time{}, false
 	}
 
-	var (
-		data []byte
-		err  error
-	)
+	return cert.NotBefore, true
+}
 
-	// convert key to byte slice ready to encode into PEM format
-	switch jwk.ALG() {
+// ExpiringKey is a key with an x5c certificate that expires soon
+type ExpiringKey struct {
+	KeyID    string
+	NotAfter time.Time
+}
+
+// ExpiringKeys returns the keys with an x5c certificate that has expired
+// or expires within the window from now
+func (j *JWKS) ExpiringKeys(now time.Time, within time.Duration) []ExpiringKey {
+	keys := make([]ExpiringKey, 0)
+	for _, jwk := range j.keyset {
+		if notAfter, ok := jwk.NotAfter(); ok && notAfter.Before(now.Add(within)) {
+			keys = append(keys, ExpiringKey{KeyID: jwk.KID(), NotAfter: notAfter})
+		}
+	}
+
+	return keys
+}
+
+// certificate returns the leaf certificate in the x5c chain or nil
+func (k *JWK) certificate() *x509.Certificate {
+	if len(k.marshal.X5C) == 0 {
+		return nil
+	}
+
+	der, err := base64.StdEncoding.DecodeString(k.marshal.X5C[0])
+	if err != nil {
+		return nil
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil
+	}
+
+	return cert
+}
+
+// PublicKey returns the public key of the JWK as a *rsa.PublicKey,
+// *ecdsa.PublicKey or ed25519.PublicKey after checking it matches the
+// algorithm of the JWK. An OKP key without an algorithm is treated as
+// EdDSA, and OKP curves other than Ed25519 are rejected with
+// ErrUnsupportedCurve.
+func (jwk *JWK) PublicKey() (crypto.PublicKey, error) {
+	// only signing curves are supported for OKP keys
+	if jwk.KTY() == string(jwkset.KtyOKP) {
+		switch crv := jwk.marshal.CRV; crv {
+		case jwkset.CrvEd25519:
+		default:
+			return nil, &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: fmt.Errorf("%w: %s", ErrUnsupportedCurve, crv)}
+		}
+	}
+
+	// key could not be parsed
+	if jwk.err != nil {
+		return nil, &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: jwk.err}
+	}
+
+	// the only supported OKP curve implies the algorithm when not given
+	alg := jwk.ALG()
+	if alg == "" && jwk.KTY() == string(jwkset.KtyOKP) {
+		alg = string(jwkset.AlgEdDSA)
+	}
+
+	switch alg {
 	case "RS256", "RS384", "RS512":
 		k, ok := jwk.key.Key().(*rsa.PublicKey)
 		if !ok {
 			return nil, &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: ErrNotRSAPublicKey}
 		}
 
-		data, err = x509.MarshalPKIXPublicKey(k)
+		return k, nil
 	case "ES256", "ES384", "ES512":
 		k, ok := jwk.key.Key().(*ecdsa.PublicKey)
 		if !ok {
 			return nil, &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: ErrNotECDSAPublicKey}
 		}
 
-		data, err = x509.MarshalPKIXPublicKey(k)
-	default:
-		return nil, &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: ErrUnsupportedAlgorithm}
+		return k, nil
+	case "EdDSA":
+		k, ok := jwk.key.Key().(ed25519.PublicKey)
+		if !ok {
+			return nil, &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: ErrNotEd25519PublicKey}
+		}
+
+		return k, nil
+	}
+
+	return nil, &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: ErrUnsupportedAlgorithm}
+}
+
+// Bytes returns the PKIX, ASN.1 DER encoded public key
+func (jwk *JWK) Bytes() ([]byte, error) {
+	// take an exclusive lock at this time in case we alter things
+	jwk.mu.Lock()
+	defer jwk.mu.Unlock()
+
+	// check if this is already done
+	if jwk.data != nil {
+		return jwk.data, nil
+	}
+
+	// grab public key
+	k, err := jwk.PublicKey()
+	if err != nil {
+		return nil, err
 	}
 
+	// convert key to byte slice ready to encode into PEM format
+	data, err := x509.MarshalPKIXPublicKey(k)
+
 	// cache data for later
 	if err == nil {
 		jwk.data = data
@@ -244,33 +912,56 @@ func (jwk *JWK) Write(name string) error {
 		return err
 	}
 
-	// create temp file
-	f, err := os.CreateTemp(filepath.Dir(name), "key*")
+	return writefile(name, jwk.KID(), data, new(writeOptions))
+}
+
+func semanticchanged(current string, data []byte) (bool, error) {
+	b, err := os.ReadFile(current)
 	if err != nil {
-		return err
+		// check error was not just due to missing file
+		if errors.Is(err, fs.ErrNotExist) {
+			return true, nil
+		}
+
+		return false, err
 	}
 
-	// save temp file name
-	tempName := f.Name()
+	// the same key in a different PEM block type needs to be rewritten
+	if formatof(b) != formatof(data) {
+		return true, nil
+	}
 
-	// close temp file and remove once done
-	defer func() {
-		f.Close()
-		os.Remove(tempName)
-	}()
+	// anything that does not parse needs to be rewritten
+	currentKey, err := parsepem(b)
+	if err != nil {
+		return true, nil
+	}
 
-	// write data to temp file
-	if _, err := f.Write(data); err != nil {
-		return err
+	newKey, err := parsepem(data)
+	if err != nil {
+		return false, err
 	}
 
-	// close temp file
-	if err := f.Close(); err != nil {
-		return err
+	k, ok := newKey.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok {
+		return true, nil
+	}
+
+	return !k.Equal(currentKey), nil
+}
+
+// parsepem parses the first PEM block in data as a public key
+func parsepem(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoPEMBlock
 	}
 
-	// move into place
-	return os.Rename(tempName, name)
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+
+	return x509.ParsePKIXPublicKey(block.Bytes)
 }
 
 func keychanged(current string, data []byte) (bool, error) {
@@ -281,6 +972,9 @@ func keychanged(current string, data []byte) (bool, error) {
 		if errors.Is(err, fs.ErrNotExist) {
 			return true, nil
 		}
+
+		// never overwrite a file that could not be read
+		return false, err
 	}
 
 	// hash new data
@@ -294,6 +988,140 @@ func keychanged(current string, data []byte) (bool, error) {
 	return !bytes.Equal(newHash, currenthash), nil
 }
 
+func formatchanged(current string, data []byte) (bool, error) {
+	b, err := os.ReadFile(current)
+	if err != nil {
+		// a missing file is not a change of format
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	return formatof(b) != formatof(data), nil
+}
+
+// formatof returns a description of the encoding of data so that keys
+// written in different formats are never considered equal
+func formatof(data []byte) string {
+	if block, _ := pem.Decode(data); block != nil {
+		return "pem:" + block.Type
+	}
+
+	if _, _, _, _, err := ssh.ParseAuthorizedKey(data); err == nil {
+		return FormatSSH
+	}
+
+	if json.Valid(data) {
+		return FormatJWK
+	}
+
+	return "unknown"
+}
+
+// validateall returns the errors of every key that could not be encoded
+func validateall(keys []*JWK, options *writeOptions) error {
+	errs := make([]error, 0)
+	for _, jwk := range keys {
+		if _, err := jwk.encode(options); err != nil {
+			errs = append(errs, options.keyerror(jwk, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// outputdir checks that the output directory exists, creating it if
+// mkdir is set
+func outputdir(output string, mkdir bool) error {
+	if mkdir {
+		if err := os.MkdirAll(output, 0755); err != nil {
+			return &WriteError{Message: "could not create output directory", Err: err}
+		}
+
+		return nil
+	}
+
+	info, err := os.Stat(output)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &WriteError{Message: "could not write keys", Err: fmt.Errorf("%w: %s", ErrNoOutputDir, output)}
+	} else if err != nil {
+		return &WriteError{Message: "could not check output directory", Err: err}
+	}
+
+	if !info.IsDir() {
+		return &WriteError{Message: "could not write keys", Err: fmt.Errorf("output is not a directory: %s", output)}
+	}
+
+	return nil
+}
+
+// unsupported reports whether err is because the key type, algorithm or
+// curve cannot be converted
+func unsupported(err error) bool {
+	return errors.Is(err, ErrUnsupportedAlgorithm) || errors.Is(err, ErrUnsupportedCurve)
+}
+
+// skip reports whether a key that could not be encoded should be skipped
+// without an error, which is the case for unsupported keys unless strict
+func (o *writeOptions) skip(jwk *JWK, err error) bool {
+	if o.strict || !unsupported(err) {
+		return false
+	}
+
+	o.log().Warn("skipping unsupported key", "kid", jwk.KID(), "alg", jwk.ALG(), "kty", jwk.KTY(), "error", err)
+
+	return true
+}
+
+// filteruse returns the keys intended for use, keeping keys that do not
+// declare a use. An empty use returns all keys.
+func filteruse(keys []*JWK, use string) []*JWK {
+	if use == "" {
+		return keys
+	}
+
+	filtered := make([]*JWK, 0, len(keys))
+	for _, jwk := range keys {
+		if jwk.USE() == "" || jwk.USE() == use {
+			filtered = append(filtered, jwk)
+		}
+	}
+
+	return filtered
+}
+
+// newest returns up to n keys ordered from newest to oldest by the
+// notBefore of their x5c certificate, or in fetch order if any key does
+// not have a certificate
+func newest(keys []*JWK, n int) []*JWK {
+	keys = slices.Clone(keys)
+
+	dated := true
+	for _, jwk := range keys {
+		if _, ok := jwk.NotBefore(); !ok {
+			dated = false
+			break
+		}
+	}
+
+	if dated {
+		slices.SortStableFunc(keys, func(a, b *JWK) int {
+			at, _ := a.NotBefore()
+			bt, _ := b.NotBefore()
+
+			return bt.Compare(at)
+		})
+	}
+
+	if len(keys) > n {
+		keys = keys[:n]
+	}
+
+	return keys
+}
+
 func hash(data []byte) ([]byte, error) {
 	hasher := sha256.New()
 	if _, err := hasher.Write(data); err != nil {
@@ -319,23 +1147,232 @@ func hashfile(name string) ([]byte, error) {
 	return hasher.Sum(nil), nil
 }
 
+// PEM returns the public key as a PEM encoded "PUBLIC KEY" block
 func (jwk *JWK) PEM() ([]byte, error) {
+	return jwk.PEMAs(PEMTypePKIX)
+}
+
+// PEMAs returns the public key as a PEM block using pemType, which is
+// PEMTypePKIX (the same as PEM) or PEMTypePKCS1 for RSA keys only
+func (jwk *JWK) PEMAs(pemType string) ([]byte, error) {
 	// grab as byte slice
-	b, err := jwk.Bytes()
+	b, err := jwk.BytesAs(pemType)
 	if err != nil {
 		return nil, err
 	}
 
+	blockType := "PUBLIC KEY"
+	if pemType == PEMTypePKCS1 {
+		blockType = "RSA PUBLIC KEY"
+	}
+
 	// encode pem version to "buf"
 	buf := new(bytes.Buffer)
-	if err := pem.Encode(buf, &pem.Block{
-		Type:  "PUBLIC KEY",
+	if err := encodepem(buf, jwk.KID(), blockType, b); err != nil {
+		return nil, err
+	}
+
+	// return data as []byte
+	return buf.Bytes(), nil
+}
+
+// BytesAs returns the DER encoded public key using pemType, which is
+// PEMTypePKIX (the same as Bytes) or PEMTypePKCS1 for RSA keys only
+func (jwk *JWK) BytesAs(pemType string) ([]byte, error) {
+	switch pemType {
+	case PEMTypePKIX, "":
+		return jwk.Bytes()
+	case PEMTypePKCS1:
+		k, err := jwk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		rsaKey, ok := k.(*rsa.PublicKey)
+		if !ok {
+			return nil, &WriteError{Message: "could not encode key", KeyID: jwk.KID(), Err: fmt.Errorf("%w: %s", ErrPKCS1NotRSA, jwk.KTY())}
+		}
+
+		return x509.MarshalPKCS1PublicKey(rsaKey), nil
+	}
+
+	return nil, &WriteError{Message: "could not encode key", KeyID: jwk.KID(), Err: fmt.Errorf("%w: %s", ErrUnsupportedPEMType, pemType)}
+}
+
+// encodepem writes the DER encoded public key b to w as a PEM block of
+// blockType
+func encodepem(w io.Writer, kid, blockType string, b []byte) error {
+	if err := pem.Encode(w, &pem.Block{
+		Type:  blockType,
 		Bytes: b,
 	}); err != nil {
-		return nil, &WriteError{Message: "could not encode to PEM format", KeyID: jwk.KID(), Err: err}
+		return &WriteError{Message: "could not encode to PEM format", KeyID: kid, Err: fmt.Errorf("%w: %w", ErrPEMEncodeFailed, err)}
+	}
+
+	return nil
+}
+
+// VerifyX5C checks that the leaf certificate in the x5c chain of the JWK,
+// if it has one, holds the same public key as the JWK itself
+func (jwk *JWK) VerifyX5C() error {
+	if len(jwk.marshal.X5C) == 0 {
+		return nil
+	}
+
+	k, err := jwk.PublicKey()
+	if err != nil {
+		return err
+	}
+
+	der, err := base64.StdEncoding.DecodeString(jwk.marshal.X5C[0])
+	if err != nil {
+		return &WriteError{Message: "invalid x5c certificate", KeyID: jwk.KID(), Err: err}
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return &WriteError{Message: "invalid x5c certificate", KeyID: jwk.KID(), Err: err}
+	}
+
+	pub, ok := k.(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !pub.Equal(cert.PublicKey) {
+		return &WriteError{Message: "invalid key", KeyID: jwk.KID(), Err: ErrX5CMismatch}
+	}
+
+	return nil
+}
+
+// CertificatesPEM returns the x5c certificate chain of the JWK as a
+// series of PEM encoded CERTIFICATE blocks, leaf first. Returns nil if the
+// JWK has no x5c chain.
+func (jwk *JWK) CertificatesPEM() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	for _, c := range jwk.marshal.X5C {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, &WriteError{Message: "invalid x5c certificate", KeyID: jwk.KID(), Err: err}
+		}
+
+		if err := pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: der}); err != nil {
+			return nil, &WriteError{Message: "could not encode to PEM format", KeyID: jwk.KID(), Err: err}
+		}
+	}
+
+	if buf.Len() == 0 {
+		return nil, nil
 	}
 
-	// return data as []byte
 	return buf.Bytes(), nil
+}
+
+// Encode returns the public key in the given format, which is one of
+// FormatPEM, FormatDER, FormatJWK or FormatSSH
+func (jwk *JWK) Encode(format string) ([]byte, error) {
+	switch format {
+	case FormatPEM, "":
+		return jwk.PEM()
+	case FormatDER:
+		return jwk.Bytes()
+	case FormatJWK:
+		// make sure only keys that can be converted are written
+		if _, err := jwk.PublicKey(); err != nil {
+			return nil, err
+		}
+
+		// strip any private key material
+		m := jwk.marshal
+		m.D, m.P, m.Q, m.DP, m.DQ, m.QI, m.OTH = "", "", "", "", "", "", nil
+
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return nil, &WriteError{Message: "could not encode to JWK format", KeyID: jwk.KID(), Err: err}
+		}
+
+		return append(data, '\n'), nil
+	case FormatSSH:
+		k, err := jwk.PublicKey()
+		if err != nil {
+			return nil, err
+		}
+
+		pub, err := ssh.NewPublicKey(k)
+		if err != nil {
+			return nil, &WriteError{Message: "could not encode to SSH format", KeyID: jwk.KID(), Err: err}
+		}
+
+		return ssh.MarshalAuthorizedKey(pub), nil
+	}
+
+	return nil, &WriteError{Message: "could not encode key", KeyID: jwk.KID(), Err: fmt.Errorf("%w: %s", ErrUnsupportedFormat, format)}
+}
+
+// Fingerprint returns the SHA-256 fingerprint of the DER encoded public
+// key in the same form as "ssh-keygen -l"
+func (jwk *JWK) Fingerprint() (string, error) {
+	b, err := jwk.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+
+	return "SHA256:" + base64.RawStdEncoding.EncodeToString(sum[:]), nil
+}
+
+// Thumbprint returns the unpadded base64url encoded SHA-256 hash of the
+// DER encoded public key
+func (jwk *JWK) Thumbprint() (string, error) {
+	b, err := jwk.Bytes()
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+// encode returns the PEM encoded JWK with any additions requested by
+// the provided options
+func (jwk *JWK) encode(options *writeOptions) ([]byte, error) {
+	if options.verifyX5C {
+		if err := jwk.VerifyX5C(); err != nil {
+			return nil, err
+		}
+	}
+
+	var data []byte
+	var err error
+	if options.pem() {
+		data, err = jwk.PEMAs(options.pemType)
+	} else {
+		data, err = jwk.Encode(options.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// use the certificate chain in place of the public key if there is one
+	if options.preferX5C && options.pem() {
+		chain, err := jwk.CertificatesPEM()
+		if err != nil {
+			return nil, err
+		}
+
+		if chain != nil {
+			data = chain
+		}
+	}
+
+	// only text formats that allow comments are annotated
+	if !options.fingerprintComment || !options.comments() {
+		return data, nil
+	}
+
+	fingerprint, err := jwk.Fingerprint()
+	if err != nil {
+		return nil, err
+	}
 
+	return append([]byte("# "+fingerprint+"\n"), data...), nil
 }
@@ -1,25 +1,61 @@
 package jwks
 
 import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/MicahParks/jwkset"
 	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
 )
 
 func Test_keychanged(t *testing.T) {
+	dir := t.TempDir()
+
+	// a file that exists but cannot be read
+	unreadable := filepath.Join(dir, "unreadable.pem")
+	if err := os.WriteFile(unreadable, []byte("test"), 0000); err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []struct {
 		name    string
 		data    []byte
 		current string
 		want    bool
 		wantErr bool
+		skip    bool
 	}{
 		{name: "missing file", data: []byte{1, 2, 3}, current: "missing.pem", want: true, wantErr: false},
 		{name: "should equal", data: []byte("This is used to test jwks.keychanged."), current: filepath.Join("..", "..", "testdata", "testfile.pem"), want: false, wantErr: false},
 		{name: "should be different", data: []byte("This is some different text."), current: filepath.Join("..", "..", "testdata", "testfile.pem"), want: true, wantErr: false},
+		{name: "directory", data: []byte{1, 2, 3}, current: dir, wantErr: true},
+		{name: "permission denied", data: []byte{1, 2, 3}, current: unreadable, wantErr: true, skip: runtime.GOOS == "windows" || os.Geteuid() == 0},
 	}
 	for _, tt := range tests {
+		if tt.skip {
+			continue
+		}
+
 		got, err := keychanged(tt.current, tt.data)
 		if tt.wantErr {
 			assert.NotNil(t, err, tt.name+": err != nil")
@@ -30,3 +66,1172 @@ func Test_keychanged(t *testing.T) {
 		assert.Equal(t, tt.want, got, tt.name+": tt.want == got")
 	}
 }
+
+func Test_parseJWKS(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// build a bare array version of the test keyset
+	var envelope struct {
+		Keys json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		lenient bool
+		want    int
+		wantErr bool
+	}{
+		{name: "strict envelope", data: data, lenient: false, want: 2, wantErr: false},
+		{name: "lenient envelope", data: data, lenient: true, want: 2, wantErr: false},
+		{name: "strict array", data: envelope.Keys, lenient: false, wantErr: true},
+		{name: "lenient array", data: envelope.Keys, lenient: true, want: 2, wantErr: false},
+	}
+	for _, tt := range tests {
+		got, err := parseJWKS(tt.data, &fetchOptions{lenient: tt.lenient})
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Len(t, got.keyset, tt.want, tt.name+": len(keyset) == tt.want")
+	}
+}
+
+func Test_parseJWKS_private(t *testing.T) {
+	public := []byte(`{"keys":[{"kty":"EC","kid":"pub","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}]}`)
+	private := []byte(`{"keys":[{"kty":"EC","kid":"priv","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0","d":"jpsQnnGQmL-YBIffH1136cspYG6-0iY7X1fCE9-E9LI"}]}`)
+
+	tests := []struct {
+		name         string
+		data         []byte
+		allowPrivate bool
+		wantErr      bool
+	}{
+		{name: "public key", data: public, allowPrivate: false, wantErr: false},
+		{name: "private key rejected", data: private, allowPrivate: false, wantErr: true},
+		{name: "private key allowed", data: private, allowPrivate: true, wantErr: false},
+	}
+	for _, tt := range tests {
+		_, err := parseJWKS(tt.data, &fetchOptions{allowPrivate: tt.allowPrivate})
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrPrivateKey, tt.name+": errors.Is(err, ErrPrivateKey)")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+	}
+}
+
+func Test_formatchanged(t *testing.T) {
+	pkix := []byte("-----BEGIN PUBLIC KEY-----\nAQAB\n-----END PUBLIC KEY-----\n")
+	pkcs1 := []byte("-----BEGIN RSA PUBLIC KEY-----\nAQAB\n-----END RSA PUBLIC KEY-----\n")
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "pkix.pem"), pkix, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		current string
+		want    bool
+	}{
+		{name: "missing file", data: pkix, current: filepath.Join(dir, "missing.pem"), want: false},
+		{name: "same format", data: pkix, current: filepath.Join(dir, "pkix.pem"), want: false},
+		{name: "different format", data: pkcs1, current: filepath.Join(dir, "pkix.pem"), want: true},
+	}
+	for _, tt := range tests {
+		got, err := formatchanged(tt.current, tt.data)
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, got, tt.name+": tt.want == got")
+	}
+}
+
+func testJWKS(t *testing.T) *JWKS {
+	t.Helper()
+
+	j, err := parseJWKS(testJWKSData(t), new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return j
+}
+
+func TestJWKS_WriteKeys_stdout(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := os.Stdout
+	os.Stdout = w
+	t.Cleanup(func() { os.Stdout = stdout })
+
+	_, err = testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", "")
+	w.Close()
+	assert.Nil(t, err, "err == nil")
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// both keys are written as separate PEM blocks
+	blocks := 0
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		blocks++
+	}
+	assert.Equal(t, 2, blocks, "PEM blocks written to stdout")
+	assert.Contains(t, string(data), "-----END PUBLIC KEY-----\n\n-----BEGIN PUBLIC KEY-----", "keys separated by a blank line")
+}
+
+func TestJWKS_WriteKeys_verify(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("verification commands are not available on windows")
+	}
+
+	tests := []struct {
+		name    string
+		command []string
+		want    bool
+		wantErr bool
+	}{
+		{name: "verify passes", command: []string{"true"}, want: true, wantErr: false},
+		{name: "verify fails", command: []string{"false"}, want: false, wantErr: true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		got, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithVerifyCommand(tt.command))
+		if tt.wantErr {
+			assert.ErrorIs(t, err, ErrVerifyFailed, tt.name+": errors.Is(err, ErrVerifyFailed)")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+		assert.Equal(t, tt.want, got, tt.name+": tt.want == got")
+
+		// failed keys must not be left behind
+		entries, err := os.ReadDir(dir)
+		assert.Nil(t, err, tt.name+": ReadDir err == nil")
+		if tt.wantErr {
+			assert.Empty(t, entries, tt.name+": no files written")
+		} else {
+			assert.Len(t, entries, 2, tt.name+": all files written")
+		}
+	}
+}
+
+func TestJWKS_Supported(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	supported := j.Supported()
+	assert.Len(t, supported.keyset, 2, "unsupported key removed")
+
+	b, err := supported.JSON()
+	assert.Nil(t, err, "err == nil")
+
+	// the filtered output should parse as a standard JWKS
+	got, err := parseJWKS(b, new(fetchOptions))
+	assert.Nil(t, err, "parse err == nil")
+	assert.Len(t, got.keyset, 2, "filtered JWKS contains supported keys")
+}
+
+func Test_semanticchanged(t *testing.T) {
+	j := testJWKS(t)
+
+	rsaPEM, err := j.keyset[0].PEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPEM, err := j.keyset[1].PEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPKCS1, err := j.keyset[0].PEMAs(PEMTypePKCS1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// re-wrap the base64 body on a single line
+	block, _ := pem.Decode(rsaPEM)
+	rewrapped := []byte("-----BEGIN PUBLIC KEY-----\n" + base64.StdEncoding.EncodeToString(block.Bytes) + "\n-----END PUBLIC KEY-----\n")
+
+	dir := t.TempDir()
+	current := filepath.Join(dir, "current.pem")
+	if err := os.WriteFile(current, rewrapped, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		current string
+		want    bool
+	}{
+		{name: "missing file", data: rsaPEM, current: filepath.Join(dir, "missing.pem"), want: true},
+		{name: "same key", data: rsaPEM, current: current, want: false},
+		{name: "different key", data: ecPEM, current: current, want: true},
+		{name: "different block type", data: rsaPKCS1, current: current, want: true},
+		{name: "not pem", data: rsaPEM, current: filepath.Join("..", "..", "testdata", "testfile.pem"), want: true},
+	}
+	for _, tt := range tests {
+		got, err := semanticchanged(tt.current, tt.data)
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, got, tt.name+": tt.want == got")
+	}
+
+	// a byte comparison sees the re-wrapped file as changed
+	got, err := keychanged(current, rsaPEM)
+	assert.Nil(t, err, "keychanged err == nil")
+	assert.True(t, got, "keychanged sees formatting difference")
+}
+
+func TestJWK_PublicKey(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		jwk     *JWK
+		want    any
+		wantErr error
+	}{
+		{name: "rsa", jwk: j.keyset[0], want: &rsa.PublicKey{}},
+		{name: "ecdsa", jwk: j.keyset[1], want: &ecdsa.PublicKey{}},
+		{name: "unsupported", jwk: j.keyset[2], wantErr: ErrUnsupportedAlgorithm},
+	}
+	for _, tt := range tests {
+		got, err := tt.jwk.PublicKey()
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.IsType(t, tt.want, got, tt.name+": key type")
+	}
+}
+
+func TestJWKS_WriteKeys_keyTypePattern(t *testing.T) {
+	dir := t.TempDir()
+	for _, sub := range []string{"rsa", "ec"} {
+		if err := os.Mkdir(filepath.Join(dir, sub), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir,
+		WithKeyTypePattern("RSA", "rsa/{{ .KeyID }}.pem"),
+		WithKeyTypePattern("EC", ""),
+	)
+	assert.Nil(t, err, "err == nil")
+
+	assert.FileExists(t, filepath.Join(dir, "rsa", "rsa-key.pem"), "RSA key uses RSA pattern")
+	assert.FileExists(t, filepath.Join(dir, "ec-key.pem"), "EC key falls back to pattern")
+}
+
+func TestJWK_encode(t *testing.T) {
+	jwk := testJWKS(t).keyset[0]
+
+	plain, err := jwk.encode(new(writeOptions))
+	assert.Nil(t, err, "err == nil")
+
+	commented, err := jwk.encode(&writeOptions{fingerprintComment: true})
+	assert.Nil(t, err, "err == nil")
+
+	fingerprint, err := jwk.Fingerprint()
+	assert.Nil(t, err, "err == nil")
+	assert.Equal(t, append([]byte("# "+fingerprint+"\n"), plain...), commented, "comment prepended")
+
+	// the comment must not stop the key being parsed
+	_, err = parsepem(commented)
+	assert.Nil(t, err, "commented PEM parses")
+}
+
+func TestJWK_Encode(t *testing.T) {
+	for _, jwk := range testJWKS(t).keyset {
+		want, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tests := []struct {
+			name   string
+			format string
+			parse  func([]byte) (any, error)
+		}{
+			{name: "pem", format: FormatPEM, parse: func(b []byte) (any, error) { return parsepem(b) }},
+			{name: "der", format: FormatDER, parse: x509.ParsePKIXPublicKey},
+			{name: "jwk", format: FormatJWK, parse: func(b []byte) (any, error) {
+				var m jwkset.JWKMarshal
+				if err := json.Unmarshal(b, &m); err != nil {
+					return nil, err
+				}
+
+				k, err := jwkset.NewJWKFromMarshal(m, jwkset.JWKMarshalOptions{}, jwkset.JWKValidateOptions{})
+				if err != nil {
+					return nil, err
+				}
+
+				return k.Key(), nil
+			}},
+			{name: "ssh", format: FormatSSH, parse: func(b []byte) (any, error) {
+				pub, _, _, _, err := ssh.ParseAuthorizedKey(b)
+				if err != nil {
+					return nil, err
+				}
+
+				return pub.(ssh.CryptoPublicKey).CryptoPublicKey(), nil
+			}},
+		}
+		for _, tt := range tests {
+			name := jwk.KID() + " " + tt.name
+
+			data, err := jwk.Encode(tt.format)
+			assert.Nil(t, err, name+": err == nil")
+
+			got, err := tt.parse(data)
+			assert.Nil(t, err, name+": parse err == nil")
+			assert.True(t, want.(interface{ Equal(crypto.PublicKey) bool }).Equal(got), name+": round trip")
+		}
+
+		_, err = jwk.Encode("xml")
+		assert.ErrorIs(t, err, ErrUnsupportedFormat, jwk.KID()+": unsupported format")
+	}
+}
+
+func TestJWKS_WriteKeys_format(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name   string
+		format string
+		want   bool
+	}{
+		{name: "first write", format: FormatSSH, want: true},
+		{name: "unchanged", format: FormatSSH, want: false},
+		{name: "new format", format: FormatDER, want: true},
+		{name: "unchanged again", format: FormatDER, want: false},
+	}
+	for _, tt := range tests {
+		got, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.key", dir, WithFormat(tt.format))
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, got, tt.name+": tt.want == got")
+	}
+}
+
+func TestJWK_PublicKey_okp(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-okp.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		jwk     *JWK
+		wantErr error
+	}{
+		{name: "ed25519", jwk: j.keyset[0], wantErr: nil},
+		{name: "x25519", jwk: j.keyset[1], wantErr: ErrUnsupportedCurve},
+		{name: "ed448", jwk: j.keyset[2], wantErr: ErrUnsupportedCurve},
+		{name: "ed25519 without alg", jwk: j.keyset[3], wantErr: nil},
+	}
+	for _, tt := range tests {
+		got, err := tt.jwk.PublicKey()
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.IsType(t, ed25519.PublicKey{}, got, tt.name+": ed25519.PublicKey")
+
+		// the key must encode to PEM
+		_, err = tt.jwk.PEM()
+		assert.Nil(t, err, tt.name+": PEM err == nil")
+	}
+}
+
+func TestJWKS_WriteKeys_splitByAlg(t *testing.T) {
+	tests := []struct {
+		name string
+		algs []string
+		want []string
+	}{
+		{name: "all algorithms", algs: nil, want: []string{filepath.Join("RS256", "rsa-key.pem"), filepath.Join("ES256", "ec-key.pem")}},
+		{name: "selected algorithms", algs: []string{"ES256", "ES384"}, want: []string{"rsa-key.pem", filepath.Join("ES256", "ec-key.pem")}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		_, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithSplitByAlg(tt.algs...))
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		for _, want := range tt.want {
+			assert.FileExists(t, filepath.Join(dir, want), tt.name+": "+want)
+		}
+	}
+}
+
+func TestJWKS_WriteKeys_algorithmMap(t *testing.T) {
+	tests := []struct {
+		name   string
+		algMap map[string]string
+		want   []string
+	}{
+		{name: "no map", algMap: nil, want: []string{"RS256-rsa-key.pem", "ES256-ec-key.pem"}},
+		{name: "partial map", algMap: map[string]string{"ES256": "ecdsa-sha256"}, want: []string{"RS256-rsa-key.pem", "ecdsa-sha256-ec-key.pem"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		_, err := testJWKS(t).WriteKeys(context.Background(), "{{ .ALG }}-{{ .KeyID }}.pem", dir, WithAlgorithmMap(tt.algMap))
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		for _, want := range tt.want {
+			assert.FileExists(t, filepath.Join(dir, want), tt.name+": "+want)
+		}
+	}
+}
+
+func TestJWKS_WriteKeys_use(t *testing.T) {
+	data := []byte(`{"keys":[
+		{"kty":"EC","use":"sig","alg":"ES256","kid":"sig-key","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"},
+		{"kty":"EC","use":"enc","alg":"ES256","kid":"enc-key","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"},
+		{"kty":"EC","alg":"ES256","kid":"any-key","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}
+	]}`)
+
+	tests := []struct {
+		name    string
+		use     string
+		want    []string
+		notWant []string
+	}{
+		{name: "no filter", use: "", want: []string{"sig-key.pem", "enc-key.pem", "any-key.pem"}},
+		{name: "signing keys", use: "sig", want: []string{"sig-key.pem", "any-key.pem"}, notWant: []string{"enc-key.pem"}},
+		{name: "encryption keys", use: "enc", want: []string{"enc-key.pem", "any-key.pem"}, notWant: []string{"sig-key.pem"}},
+	}
+	for _, tt := range tests {
+		j, err := parseJWKS(data, new(fetchOptions))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		dir := t.TempDir()
+
+		_, err = j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithUse(tt.use))
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		for _, want := range tt.want {
+			assert.FileExists(t, filepath.Join(dir, want), tt.name+": "+want)
+		}
+		for _, notWant := range tt.notWant {
+			assert.NoFileExists(t, filepath.Join(dir, notWant), tt.name+": "+notWant)
+		}
+	}
+}
+
+func TestJWKS_WriteKeys_patternData(t *testing.T) {
+	j := testJWKS(t)
+
+	thumbprint, err := j.keyset[0].Thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a key without an id
+	data := []byte(`{"keys":[{"kty":"EC","use":"sig","alg":"ES256","crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}]}`)
+	noKID, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		jwks    *JWKS
+		pattern string
+		want    string
+	}{
+		{name: "index", jwks: j, pattern: "{{ .Index }}.pem", want: "0.pem"},
+		{name: "key id", jwks: j, pattern: "{{ .KeyID }}.pem", want: "rsa-key.pem"},
+		{name: "alg", jwks: j, pattern: "{{ .ALG }}-{{ .KeyID }}.pem", want: "RS256-rsa-key.pem"},
+		{name: "kty", jwks: j, pattern: "{{ .KTY }}-{{ .KeyID }}.pem", want: "RSA-rsa-key.pem"},
+		{name: "use", jwks: j, pattern: "{{ .Use }}-{{ .KeyID }}.pem", want: "sig-rsa-key.pem"},
+		{name: "thumbprint", jwks: j, pattern: "{{ .Thumbprint }}.pem", want: thumbprint + ".pem"},
+		{name: "missing key id", jwks: noKID, pattern: "key-{{ .KeyID }}.pem", want: "key-0.pem"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		_, err := tt.jwks.WriteKeys(context.Background(), tt.pattern, dir)
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.FileExists(t, filepath.Join(dir, tt.want), tt.name+": "+tt.want)
+	}
+}
+
+func TestJWKS_WriteKeys_unsafeKID(t *testing.T) {
+	key := `{"kty":"EC","use":"sig","alg":"ES256","kid":%q,"crv":"P-256","x":"f83OJ3D2xF1Bg8vub9tLe1gHMzV76e8Tus9uPHvRVEU","y":"x_FEzRu9m36HLN_tue659LNpXW6pCyStikYjKIWI5a0"}`
+
+	tests := []struct {
+		name    string
+		kid     string
+		pattern string
+		want    string
+		wantErr error
+	}{
+		{name: "traversal", kid: "../../etc/evil", pattern: "{{ .KeyID }}.pem", want: ".._.._etc_evil.pem"},
+		{name: "sub-directory", kid: "a/b", pattern: "{{ .KeyID }}.pem", want: "a_b.pem"},
+		{name: "backslash", kid: `a\b`, pattern: "{{ .KeyID }}.pem", want: "a_b.pem"},
+		{name: "pattern traversal", kid: "..", pattern: "{{ .KeyID }}/../../evil.pem", wantErr: ErrUnsafePath},
+		{name: "absolute pattern", kid: "key", pattern: "/tmp/{{ .KeyID }}.pem", wantErr: ErrUnsafePath},
+	}
+	for _, tt := range tests {
+		j, err := parseJWKS([]byte(`{"keys":[`+fmt.Sprintf(key, tt.kid)+`]}`), new(fetchOptions))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		base := t.TempDir()
+		output := filepath.Join(base, "out", "keys")
+		if err := os.MkdirAll(output, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = j.WriteKeys(context.Background(), tt.pattern, output)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+			assert.FileExists(t, filepath.Join(output, tt.want), tt.name+": "+tt.want)
+		}
+
+		// nothing may be written outside of the output directory
+		entries, err := os.ReadDir(filepath.Join(base, "out"))
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Len(t, entries, 1, tt.name+": only the output directory")
+	}
+}
+
+func TestJWKS_WriteKeys_slots(t *testing.T) {
+	now := time.Now()
+	dated := &JWKS{keyset: []*JWK{
+		testCertJWK(t, "old", now.Add(-time.Hour*48), now.Add(time.Hour)),
+		testCertJWK(t, "newest", now, now.Add(time.Hour)),
+		testCertJWK(t, "newer", now.Add(-time.Hour*24), now.Add(time.Hour)),
+	}}
+
+	tests := []struct {
+		name  string
+		j     *JWKS
+		slots int
+		want  map[string]string
+	}{
+		{name: "fetch order", j: testJWKS(t), slots: 1, want: map[string]string{"key0.pem": "rsa-key"}},
+		{name: "more slots than keys", j: testJWKS(t), slots: 3, want: map[string]string{"key0.pem": "rsa-key", "key1.pem": "ec-key"}},
+		{name: "certificate order", j: dated, slots: 2, want: map[string]string{"key0.pem": "newest", "key1.pem": "newer"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		_, err := tt.j.WriteKeys(context.Background(), "key{{ .Index }}.pem", dir, WithSlots(tt.slots))
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		files, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, files, len(tt.want), tt.name+": number of slots written")
+
+		for _, jwk := range tt.j.keyset {
+			for name, kid := range tt.want {
+				if jwk.KID() != kid {
+					continue
+				}
+
+				want, err := jwk.PEM()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got, err := os.ReadFile(filepath.Join(dir, name))
+				assert.Nil(t, err, tt.name+": "+name+" exists")
+				assert.Equal(t, want, got, tt.name+": "+name+" contains "+kid)
+			}
+		}
+	}
+}
+
+func TestJWKS_WriteKeys_allOrNothing(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		opts    []WriteOption
+		files   int
+		wantErr error
+	}{
+		{name: "best effort", opts: nil, files: 2, wantErr: nil},
+		{name: "best effort strict", opts: []WriteOption{WithStrict()}, files: 2, wantErr: ErrUnsupportedAlgorithm},
+		{name: "all or nothing", opts: []WriteOption{WithAllOrNothing()}, files: 0, wantErr: ErrUnsupportedAlgorithm},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		j, err := parseJWKS(data, new(fetchOptions))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		changed, err := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, tt.opts...)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": unsupported key reported")
+		} else {
+			assert.Nil(t, err, tt.name+": unsupported key skipped")
+		}
+		assert.Equal(t, tt.files > 0, changed, tt.name+": changed")
+
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Len(t, entries, tt.files, tt.name+": files written")
+	}
+}
+
+func TestJWKS_WriteKeys_kidHash(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []WriteOption
+		want []string
+	}{
+		{name: "plain kid", opts: nil, want: []string{"rsa-key.pem", "ec-key.pem"}},
+		// first 16 hex characters of sha256("rsa-key") and sha256("ec-key")
+		{name: "hashed kid", opts: []WriteOption{WithKIDHash()}, want: []string{"1e489102a37e443b.pem", "8d75046bcf48b2a3.pem"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		_, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, tt.opts...)
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		for _, want := range tt.want {
+			assert.FileExists(t, filepath.Join(dir, want), tt.name+": "+want)
+		}
+	}
+}
+
+func Test_tempsafe(t *testing.T) {
+	tests := []struct {
+		name string
+		s    string
+		want string
+	}{
+		{name: "safe", s: "key-1.pem", want: "key-1.pem"},
+		{name: "path separators", s: "../a/b", want: ".._a_b"},
+		{name: "url", s: "https://example.com/k", want: "https___example.com_k"},
+		{name: "long", s: strings.Repeat("a", 100), want: strings.Repeat("a", 64)},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tempsafe(tt.s), tt.name+": tt.want == got")
+	}
+}
+
+func TestJWKS_WriteKeys_followSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("symlinks require additional privileges on windows")
+	}
+
+	tests := []struct {
+		name    string
+		opts    []WriteOption
+		symlink bool
+	}{
+		{name: "replace symlink", opts: nil, symlink: false},
+		{name: "follow symlink", opts: []WriteOption{WithFollowSymlinks()}, symlink: true},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, "v1"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join("v1", "rsa-key.pem"), filepath.Join(dir, "rsa-key.pem")); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, tt.opts...)
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		fi, err := os.Lstat(filepath.Join(dir, "rsa-key.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tt.symlink, fi.Mode()&fs.ModeSymlink != 0, tt.name+": symlink preserved")
+
+		if tt.symlink {
+			assert.FileExists(t, filepath.Join(dir, "v1", "rsa-key.pem"), tt.name+": target written")
+		}
+	}
+}
+
+func TestJWKS_WriteKeys_fileMode(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("file modes are not tested on windows")
+	}
+
+	tests := []struct {
+		name string
+		opts []WriteOption
+		want fs.FileMode
+	}{
+		{name: "default", opts: nil, want: DefaultFileMode},
+		{name: "group only", opts: []WriteOption{WithFileMode(0640)}, want: 0640},
+		{name: "world writable", opts: []WriteOption{WithFileMode(0666)}, want: 0666},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		_, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, tt.opts...)
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		info, err := os.Stat(filepath.Join(dir, "rsa-key.pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tt.want, info.Mode().Perm(), tt.name+": tt.want == info.Mode().Perm()")
+	}
+}
+
+func TestJWKS_WriteKeys_preferX5C(t *testing.T) {
+	j := testJWKS(t)
+	j.keyset = append(j.keyset, testCertJWK(t, "x5c-key", time.Unix(1700000000, 0), time.Unix(1800000000, 0)))
+
+	tests := []struct {
+		name string
+		opts []WriteOption
+		kid  string
+		want string
+	}{
+		{name: "default", opts: nil, kid: "x5c-key", want: "PUBLIC KEY"},
+		{name: "chain preferred", opts: []WriteOption{WithPreferX5C()}, kid: "x5c-key", want: "CERTIFICATE"},
+		{name: "no chain", opts: []WriteOption{WithPreferX5C()}, kid: "rsa-key", want: "PUBLIC KEY"},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+
+		_, err := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, tt.opts...)
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		data, err := os.ReadFile(filepath.Join(dir, tt.kid+".pem"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		block, rest := pem.Decode(data)
+		if assert.NotNil(t, block, tt.name+": block != nil") {
+			assert.Equal(t, tt.want, block.Type, tt.name+": tt.want == block.Type")
+		}
+		assert.Empty(t, strings.TrimSpace(string(rest)), tt.name+": one block per chain element")
+
+		// the certificate must be the one from the chain
+		if tt.want == "CERTIFICATE" {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			assert.Nil(t, err, tt.name+": err == nil")
+			assert.Equal(t, "x5c-key", cert.Subject.CommonName, tt.name+": certificate subject")
+		}
+	}
+}
+
+func TestJWK_VerifyX5C(t *testing.T) {
+	notBefore, notAfter := time.Unix(1700000000, 0), time.Unix(1800000000, 0)
+
+	// a key published with the certificate of another key
+	mismatched := testCertJWK(t, "mismatched-key", notBefore, notAfter)
+	mismatched.marshal.X5C = testCertJWK(t, "other-key", notBefore, notAfter).marshal.X5C
+
+	// a key with a chain that cannot be parsed
+	invalid := testCertJWK(t, "invalid-key", notBefore, notAfter)
+	invalid.marshal.X5C = []string{"bm90IGEgY2VydGlmaWNhdGU="}
+
+	tests := []struct {
+		name    string
+		jwk     *JWK
+		wantErr string
+	}{
+		{name: "no chain", jwk: testJWKS(t).keyset[0], wantErr: ""},
+		{name: "matching", jwk: testCertJWK(t, "x5c-key", notBefore, notAfter), wantErr: ""},
+		{name: "mismatched", jwk: mismatched, wantErr: ErrX5CMismatch.Error()},
+		{name: "invalid", jwk: invalid, wantErr: "invalid x5c certificate"},
+	}
+	for _, tt := range tests {
+		err := tt.jwk.VerifyX5C()
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.name+": error")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+
+		// keys are only checked when asked to
+		j := &JWKS{keyset: []*JWK{tt.jwk}}
+		_, err = j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", t.TempDir())
+		assert.Nil(t, err, tt.name+": err == nil without WithVerifyX5C")
+
+		_, err = j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", t.TempDir(), WithVerifyX5C())
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.name+": error with WithVerifyX5C")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil with WithVerifyX5C")
+		}
+	}
+}
+
+func TestJWKS_WriteKeys_duplicateKID(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-duplicate.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// keys without an id are named by their index
+	dir := t.TempDir()
+	_, err = j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir)
+	assert.Nil(t, err, "err == nil")
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make([]string, 0)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{"1.pem", "2.pem", "dup-key.pem"}, names, "one file per key id")
+
+	// the first key with the duplicate id is kept
+	got, err := os.ReadFile(filepath.Join(dir, "dup-key.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := j.keyset[0].PEM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, want, got, "first key kept")
+	assert.Equal(t, "VH6hkJPbYC4E5nSGNd9FOgJ1YH2QMYymaGboaZAzn4A", j.keyset[0].marshal.X, "first key kept")
+}
+
+func TestJWKS_WriteKeys_cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	dir := t.TempDir()
+	changed, err := testJWKS(t).WriteKeys(ctx, "{{ .KeyID }}.pem", dir, WithPrune())
+	assert.ErrorIs(t, err, context.Canceled, "errors.Is(err, context.Canceled)")
+	assert.False(t, changed, "nothing changed")
+
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err, "err == nil")
+	assert.Empty(t, entries, "no keys written")
+}
+
+func TestJWKS_WriteCounts(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one RSA key and one unsupported HMAC key
+	j.keyset = []*JWK{j.keyset[0], j.keyset[2]}
+
+	dir := t.TempDir()
+
+	// a directory in place of the key file cannot be written over
+	blocked := t.TempDir()
+	if err := os.Mkdir(filepath.Join(blocked, "rsa-key.pem"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		dir     string
+		want    WriteCounts
+		wantErr bool
+	}{
+		{name: "first write", dir: dir, want: WriteCounts{Written: 1, Skipped: 1}, wantErr: false},
+		{name: "no changes", dir: dir, want: WriteCounts{Unchanged: 1, Skipped: 1}, wantErr: false},
+		{name: "write failed", dir: blocked, want: WriteCounts{Skipped: 1, Errored: 1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", tt.dir)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+		} else {
+			assert.Nil(t, err, tt.name+": unsupported key skipped")
+		}
+		assert.Equal(t, tt.want, j.WriteCounts(), tt.name+": tt.want == j.WriteCounts()")
+	}
+}
+
+func TestJWKS_WriteKeys_dryRun(t *testing.T) {
+	dir := t.TempDir()
+	stale := filepath.Join(dir, "stale.pem")
+	if err := os.WriteFile(stale, []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	j := testJWKS(t)
+	changed, err := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithPrune(), WithDryRun())
+	assert.Nil(t, err, "err == nil")
+	assert.True(t, changed, "changes reported")
+	assert.Equal(t, Changes{Added: []string{"rsa-key", "ec-key"}, Removed: []string{stale}}, j.Changes(), "would-be changes reported")
+
+	// nothing was written or removed
+	entries, err := os.ReadDir(dir)
+	assert.Nil(t, err, "err == nil")
+	if assert.Len(t, entries, 1, "no keys written") {
+		assert.Equal(t, "stale.pem", entries[0].Name(), "stale key kept")
+	}
+}
+
+// errWriter fails every write
+type errWriter struct{}
+
+func (errWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("write failed")
+}
+
+func Test_encodepem(t *testing.T) {
+	b, err := testJWKS(t).keyset[0].Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := new(bytes.Buffer)
+	assert.Nil(t, encodepem(buf, "rsa-key", "PUBLIC KEY", b), "err == nil")
+	assert.True(t, strings.HasPrefix(buf.String(), "-----BEGIN PUBLIC KEY-----\n"), "PEM block written")
+
+	err = encodepem(errWriter{}, "rsa-key", "PUBLIC KEY", b)
+	assert.ErrorIs(t, err, ErrPEMEncodeFailed, "errors.Is(err, ErrPEMEncodeFailed)")
+
+	var writeErr *WriteError
+	if assert.ErrorAs(t, err, &writeErr, "errors.As(err, *WriteError)") {
+		assert.Equal(t, "rsa-key", writeErr.KeyID, "kid of failed key")
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  *WriteError
+		want string
+	}{
+		{name: "with kid", err: &WriteError{Message: "invalid key", KeyID: "rsa-key", Err: ErrNotRSAPublicKey}, want: "invalid key (KID: rsa-key): was not a RSA public key"},
+		{name: "without kid", err: &WriteError{Message: "pattern could not be parsed", Err: ErrPatternNotParsed}, want: "pattern could not be parsed: pattern could not be parsed"},
+		{name: "without err", err: &WriteError{Message: "invalid key", KeyID: "rsa-key"}, want: "invalid key (KID: rsa-key)"},
+		{name: "nested", err: &WriteError{Message: "writing key failed", KeyID: "rsa-key", Err: &WriteError{Message: "invalid key", KeyID: "rsa-key", Err: ErrNotRSAPublicKey}}, want: "writing key failed (KID: rsa-key): invalid key (KID: rsa-key): was not a RSA public key"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.err.Error(), tt.name+": tt.want == tt.err.Error()")
+	}
+
+	// errors.Is sees through nested errors
+	assert.ErrorIs(t, tests[3].err, ErrNotRSAPublicKey, "nested: errors.Is(err, ErrNotRSAPublicKey)")
+}
+
+func TestJWKS_WriteKeys_errorsIs(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// label the EC key as an RSA key
+	data = bytes.Replace(data, []byte(`"alg": "ES256"`), []byte(`"alg": "RS256"`), 1)
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", t.TempDir())
+	assert.ErrorIs(t, err, ErrNotRSAPublicKey, "errors.Is(err, ErrNotRSAPublicKey)")
+	assert.Contains(t, err.Error(), "invalid key (KID: ec-key): was not a RSA public key", "formatted error")
+}
+
+func TestJWKS_WriteKeys_mkdir(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		opts    []WriteOption
+		want    []string
+		wantErr error
+	}{
+		{name: "missing directory", pattern: "{{ .KeyID }}.pem", opts: nil, wantErr: ErrNoOutputDir},
+		{name: "mkdir", pattern: "{{ .KeyID }}.pem", opts: []WriteOption{WithMkdir()}, want: []string{"rsa-key.pem", "ec-key.pem"}},
+		{name: "nested pattern", pattern: "{{ .ALG }}/{{ .KeyID }}.pem", opts: []WriteOption{WithMkdir()}, want: []string{filepath.Join("RS256", "rsa-key.pem"), filepath.Join("ES256", "ec-key.pem")}},
+	}
+	for _, tt := range tests {
+		dir := filepath.Join(t.TempDir(), "missing", "keys")
+
+		_, err := testJWKS(t).WriteKeys(context.Background(), tt.pattern, dir, tt.opts...)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+			assert.NoDirExists(t, dir, tt.name+": directory not created")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		for _, name := range tt.want {
+			assert.FileExists(t, filepath.Join(dir, name), tt.name+": "+name+" written")
+		}
+	}
+}
+
+func TestJWK_PEMAs(t *testing.T) {
+	j := testJWKS(t)
+
+	tests := []struct {
+		name      string
+		jwk       *JWK
+		pemType   string
+		wantBlock string
+		wantErr   error
+	}{
+		{name: "rsa pkix", jwk: j.keyset[0], pemType: PEMTypePKIX, wantBlock: "PUBLIC KEY"},
+		{name: "rsa pkcs1", jwk: j.keyset[0], pemType: PEMTypePKCS1, wantBlock: "RSA PUBLIC KEY"},
+		{name: "ec pkix", jwk: j.keyset[1], pemType: PEMTypePKIX, wantBlock: "PUBLIC KEY"},
+		{name: "ec pkcs1", jwk: j.keyset[1], pemType: PEMTypePKCS1, wantErr: ErrPKCS1NotRSA},
+		{name: "unknown type", jwk: j.keyset[0], pemType: "pkcs8", wantErr: ErrUnsupportedPEMType},
+	}
+	for _, tt := range tests {
+		data, err := tt.jwk.PEMAs(tt.pemType)
+		if tt.wantErr != nil {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		block, _ := pem.Decode(data)
+		if assert.NotNil(t, block, tt.name+": PEM block") {
+			assert.Equal(t, tt.wantBlock, block.Type, tt.name+": block type")
+		}
+
+		// both encodings hold the same key
+		got, err := parsepem(data)
+		assert.Nil(t, err, tt.name+": parsepem err == nil")
+		want, _ := tt.jwk.PublicKey()
+		assert.True(t, want.(interface{ Equal(crypto.PublicKey) bool }).Equal(got), tt.name+": same public key")
+	}
+
+	// the option is passed through when writing keys
+	dir := t.TempDir()
+	_, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithPEMType(PEMTypePKCS1))
+	assert.ErrorIs(t, err, ErrPKCS1NotRSA, "errors.Is(err, ErrPKCS1NotRSA)")
+
+	data, err := os.ReadFile(filepath.Join(dir, "rsa-key.pem"))
+	if assert.Nil(t, err, "rsa key written") {
+		assert.True(t, strings.HasPrefix(string(data), "-----BEGIN RSA PUBLIC KEY-----\n"), "PKCS#1 block written")
+	}
+
+	// changing back is a change even when comparing the keys themselves
+	changed, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithSemanticCompare())
+	assert.Nil(t, err, "err == nil")
+	assert.True(t, changed, "rewritten in the new PEM type")
+
+	data, err = os.ReadFile(filepath.Join(dir, "rsa-key.pem"))
+	if assert.Nil(t, err, "rsa key written") {
+		assert.True(t, strings.HasPrefix(string(data), "-----BEGIN PUBLIC KEY-----\n"), "PKIX block written")
+	}
+}
+
+func TestJWKS_Keys(t *testing.T) {
+	j := testJWKS(t)
+
+	keys := j.Keys()
+	if assert.Len(t, keys, 2, "all keys returned") {
+		assert.Equal(t, "rsa-key", keys[0].KID(), "keys in order")
+		assert.Equal(t, "ec-key", keys[1].KID(), "keys in order")
+	}
+
+	// changing the returned slice does not change the JWKS
+	keys[0] = nil
+	assert.NotNil(t, j.Keys()[0], "copy of keys returned")
+}
+
+func TestJWKS_WriteKeys_logger(t *testing.T) {
+	dir := t.TempDir()
+
+	tests := []struct {
+		name    string
+		pattern string
+		want    string
+	}{
+		{name: "new key", pattern: "{{ .KeyID }}.pem", want: "wrote key"},
+		{name: "unchanged key", pattern: "{{ .KeyID }}.pem", want: "skipping unchanged key"},
+		{name: "failed key", pattern: "../{{ .KeyID }}.pem", want: "key could not be written"},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		logger := slog.New(slog.NewJSONHandler(buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+		testJWKS(t).WriteKeys(context.Background(), tt.pattern, dir, WithLogger(logger))
+
+		// collect the attributes logged for each key
+		got := make(map[string]string)
+		dec := json.NewDecoder(buf)
+		for {
+			var line map[string]any
+			if err := dec.Decode(&line); err != nil {
+				break
+			}
+
+			if line["msg"] == tt.want {
+				kid, _ := line["kid"].(string)
+				alg, _ := line["alg"].(string)
+				got[kid] = alg
+			}
+		}
+
+		assert.Equal(t, map[string]string{"rsa-key": "RS256", "ec-key": "ES256"}, got, tt.name+": kid and alg logged")
+	}
+}
@@ -0,0 +1,62 @@
+package jwks
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// KeySummary describes a key in the JWKS and whether it can be converted
+type KeySummary struct {
+	KeyID       string `json:"kid"`
+	ALG         string `json:"alg"`
+	KTY         string `json:"kty"`
+	Use         string `json:"use,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Error       string `json:"error,omitempty"`
+}
+
+// Summary returns a summary of every key in the JWKS
+func (j *JWKS) Summary() []KeySummary {
+	keys := make([]KeySummary, 0, len(j.keyset))
+	for _, jwk := range j.keyset {
+		ks := KeySummary{
+			KeyID: jwk.KID(),
+			ALG:   jwk.ALG(),
+			KTY:   jwk.KTY(),
+			Use:   jwk.USE(),
+		}
+
+		if fingerprint, err := jwk.Fingerprint(); err != nil {
+			ks.Error = err.Error()
+		} else {
+			ks.Fingerprint = fingerprint
+		}
+
+		keys = append(keys, ks)
+	}
+
+	return keys
+}
+
+// WriteSummary writes the summary of every key in the JWKS to w as an
+// indented JSON array, or as one compact JSON object per line if jsonl
+// is true
+func (j *JWKS) WriteSummary(w io.Writer, jsonl bool) error {
+	keys := j.Summary()
+
+	if !jsonl {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(keys)
+	}
+
+	enc := json.NewEncoder(w)
+	for _, ks := range keys {
+		if err := enc.Encode(ks); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package jwks
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKS_WriteSummary(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("..", "..", "testdata", "jwks-mixed.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	j, err := parseJWKS(data, new(fetchOptions))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		jsonl bool
+		lines int
+	}{
+		{name: "json array", jsonl: false, lines: 0},
+		{name: "jsonl", jsonl: true, lines: 3},
+	}
+	for _, tt := range tests {
+		buf := new(bytes.Buffer)
+		err := j.WriteSummary(buf, tt.jsonl)
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		var keys []KeySummary
+		if tt.jsonl {
+			lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+			assert.Len(t, lines, tt.lines, tt.name+": one line per key")
+
+			for _, line := range lines {
+				var ks KeySummary
+				assert.Nil(t, json.Unmarshal([]byte(line), &ks), tt.name+": line is a JSON object")
+				keys = append(keys, ks)
+			}
+		} else {
+			assert.Nil(t, json.Unmarshal(buf.Bytes(), &keys), tt.name+": output is a JSON array")
+		}
+
+		assert.Len(t, keys, 3, tt.name+": all keys listed")
+		assert.True(t, strings.HasPrefix(keys[0].Fingerprint, "SHA256:"), tt.name+": fingerprint of supported key")
+		assert.NotEmpty(t, keys[2].Error, tt.name+": error for unsupported key")
+	}
+}
@@ -0,0 +1,64 @@
+package jwks
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// ManifestEntry describes a key file handled by the last call to WriteKeys
+type ManifestEntry struct {
+	KeyID      string `json:"kid"`
+	ALG        string `json:"alg"`
+	File       string `json:"file"`
+	Thumbprint string `json:"thumbprint"`
+	Changed    bool   `json:"changed"`
+}
+
+// Manifest returns an entry for each key written or left unchanged by the
+// last call to WriteKeys. Keys that were skipped or failed are not included.
+func (j *JWKS) Manifest() []ManifestEntry {
+	if j.manifest == nil {
+		return []ManifestEntry{}
+	}
+
+	return j.manifest
+}
+
+// WriteManifest writes the manifest of the last call to WriteKeys as a
+// JSON list to "name", or to w if name is "-"
+func (j *JWKS) WriteManifest(w io.Writer, name string) error {
+	data, err := json.MarshalIndent(j.Manifest(), "", "  ")
+	if err != nil {
+		return &WriteError{Message: "could not encode manifest", Err: err}
+	}
+	data = append(data, '\n')
+
+	if name == "-" {
+		if _, err := w.Write(data); err != nil {
+			return &WriteError{Message: "writing manifest failed", Err: err}
+		}
+
+		return nil
+	}
+
+	if err := writefile(name, "", data, new(writeOptions)); err != nil {
+		return &WriteError{Message: "writing manifest failed", Err: err}
+	}
+
+	return nil
+}
+
+// manifestentry returns the manifest entry for jwk written to file, with
+// the algorithm named as it is in file names
+func (o *writeOptions) manifestentry(jwk *JWK, file string, changed bool) ManifestEntry {
+	// keys that were written can always be converted
+	thumbprint, _ := jwk.Thumbprint()
+
+	return ManifestEntry{
+		KeyID:      jwk.KID(),
+		ALG:        o.algname(jwk.ALG()),
+		File:       file,
+		Thumbprint: thumbprint,
+		Changed:    changed,
+	}
+}
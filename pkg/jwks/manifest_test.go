@@ -0,0 +1,83 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKS_WriteManifest(t *testing.T) {
+	j := testJWKS(t)
+
+	dir := t.TempDir()
+	name := filepath.Join(t.TempDir(), "manifest.json")
+
+	tests := []struct {
+		name    string
+		changed bool
+	}{
+		{name: "first write", changed: true},
+		{name: "no changes", changed: false},
+	}
+	for _, tt := range tests {
+		if _, err := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir); err != nil {
+			t.Fatal(err)
+		}
+		assert.Nil(t, j.WriteManifest(nil, name), tt.name+": err == nil")
+
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []map[string]any
+		assert.Nil(t, json.Unmarshal(b, &got), tt.name+": manifest is valid JSON")
+		if !assert.Len(t, got, 2, tt.name+": one entry per key") {
+			continue
+		}
+
+		for n, kid := range []string{"rsa-key", "ec-key"} {
+			thumbprint, err := j.keyset[n].Thumbprint()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			assert.Equal(t, map[string]any{
+				"kid":        kid,
+				"alg":        j.keyset[n].ALG(),
+				"file":       filepath.Join(dir, kid+".pem"),
+				"thumbprint": thumbprint,
+				"changed":    tt.changed,
+			}, got[n], tt.name+": entry for "+kid)
+		}
+	}
+
+	// "-" writes to the provided writer instead
+	buf := new(bytes.Buffer)
+	assert.Nil(t, j.WriteManifest(buf, "-"), "stdout: err == nil")
+
+	var got []ManifestEntry
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &got), "stdout: manifest is valid JSON")
+	assert.Equal(t, j.Manifest(), got, "stdout: same manifest")
+}
+
+func TestJWKS_Manifest_algMap(t *testing.T) {
+	j := testJWKS(t)
+	dir := t.TempDir()
+
+	if _, err := j.WriteKeys(context.Background(), "{{ .ALG }}-{{ .KeyID }}.pem", dir, WithAlgorithmMap(map[string]string{"RS256": "rsa-sha256"})); err != nil {
+		t.Fatal(err)
+	}
+
+	got := j.Manifest()
+	if assert.Len(t, got, 2, "one entry per key") {
+		assert.Equal(t, "rsa-sha256", got[0].ALG, "mapped algorithm")
+		assert.Equal(t, filepath.Join(dir, "rsa-sha256-rsa-key.pem"), got[0].File, "file named with mapped algorithm")
+		assert.Equal(t, "ES256", got[1].ALG, "unmapped algorithm")
+	}
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package jwks
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// dirowner returns the uid and gid of the provided directory
+func dirowner(name string) (int, int, error) {
+	fi, err := os.Stat(name)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, errors.ErrUnsupported
+	}
+
+	return int(st.Uid), int(st.Gid), nil
+}
@@ -0,0 +1,8 @@
+package jwks
+
+import "errors"
+
+// dirowner is not supported on windows
+func dirowner(name string) (int, int, error) {
+	return 0, 0, errors.ErrUnsupported
+}
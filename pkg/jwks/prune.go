@@ -0,0 +1,128 @@
+package jwks
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WithPrune removes files from the output directory that the pattern
+// could have produced but which do not belong to a key in the JWKS. Files
+// for the key ids in exclude, which may be glob patterns, are never
+// removed.
+func WithPrune(exclude ...string) WriteOption {
+	return func(o *writeOptions) {
+		o.prune = true
+		o.pruneExclude = exclude
+	}
+}
+
+// prunefiles removes files below output that match one of the templates
+// but were not written for a current key, returning the removed paths
+func (o *writeOptions) prunefiles(output string, t *template.Template, templates map[string]*template.Template, written map[string]bool) ([]string, error) {
+	all := []*template.Template{t}
+	for _, kt := range templates {
+		all = append(all, kt)
+	}
+
+	// files that could have been produced for any key
+	candidates, err := o.pruneglobs(all, "*")
+	if err != nil {
+		return nil, err
+	}
+
+	// files that must be kept
+	excluded := make([]string, 0)
+	for _, kid := range o.pruneExclude {
+		globs, err := o.pruneglobs(all, o.kidname(kid))
+		if err != nil {
+			return nil, err
+		}
+		excluded = append(excluded, globs...)
+	}
+
+	pruned := make([]string, 0)
+	errs := make([]error, 0)
+	err = filepath.WalkDir(output, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || written[path] {
+			return err
+		}
+
+		rel, err := filepath.Rel(output, path)
+		if err != nil {
+			return err
+		}
+
+		if !globmatch(candidates, rel) || globmatch(excluded, rel) || globmatch(o.pruneExclude, d.Name()) {
+			return nil
+		}
+
+		if o.dryRun {
+			o.log().Info("dry run: would remove key that is no longer in the JWKS", "path", path)
+			pruned = append(pruned, path)
+
+			return nil
+		}
+
+		if err := os.Remove(path); err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+
+		o.log().Info("removed key that is no longer in the JWKS", "path", path)
+		pruned = append(pruned, path)
+
+		return nil
+	})
+
+	return pruned, errors.Join(append(errs, err)...)
+}
+
+// pruneglobs returns the relative paths, as glob patterns, produced by
+// the templates for the key id kid with any index or algorithm
+func (o *writeOptions) pruneglobs(templates []*template.Template, kid string) ([]string, error) {
+	globs := make([]string, 0, len(templates))
+	for _, t := range templates {
+		name := new(bytes.Buffer)
+		if err := t.Execute(name, struct {
+			Index      string
+			KeyID      string
+			ALG        string
+			KTY        string
+			Use        string
+			Thumbprint string
+		}{
+			Index:      "*",
+			KeyID:      kid,
+			ALG:        "*",
+			KTY:        "*",
+			Use:        "*",
+			Thumbprint: "*",
+		}); err != nil {
+			return nil, err
+		}
+
+		globs = append(globs, name.String())
+
+		// keys may also be within a sub-directory per algorithm
+		if o.splitByAlg {
+			globs = append(globs, filepath.Join("*", name.String()))
+		}
+	}
+
+	return globs, nil
+}
+
+// globmatch reports if name matches any of the glob patterns
+func globmatch(patterns []string, name string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
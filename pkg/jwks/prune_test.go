@@ -0,0 +1,90 @@
+package jwks
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKS_WriteKeys_prune(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    []WriteOption
+		removed []string
+		kept    []string
+	}{
+		{name: "no prune", opts: nil, removed: nil, kept: []string{"stale.pem", "static-1.pem", "manual.pem", "notes.txt"}},
+		{name: "prune", opts: []WriteOption{WithPrune()}, removed: []string{"stale.pem", "static-1.pem", "manual.pem"}, kept: []string{"notes.txt"}},
+		{name: "prune with exclusions", opts: []WriteOption{WithPrune("static-*", "manual")}, removed: []string{"stale.pem"}, kept: []string{"static-1.pem", "manual.pem", "notes.txt"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		for _, name := range []string{"stale.pem", "static-1.pem", "manual.pem", "notes.txt"} {
+			if err := os.WriteFile(filepath.Join(dir, name), []byte("test"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		changed, err := testJWKS(t).WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, tt.opts...)
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.True(t, changed, tt.name+": changed")
+
+		for _, name := range append(tt.kept, "rsa-key.pem", "ec-key.pem") {
+			assert.FileExists(t, filepath.Join(dir, name), tt.name+": "+name+" kept")
+		}
+		for _, name := range tt.removed {
+			assert.NoFileExists(t, filepath.Join(dir, name), tt.name+": "+name+" removed")
+		}
+	}
+}
+
+func TestJWKS_WriteKeys_prune_failed(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"stale.pem", "broken.pem"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("test"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a key that is still in the JWKS but cannot be encoded
+	j := testJWKS(t)
+	j.keyset = append(j.keyset, &JWK{marshal: jwkset.JWKMarshal{KID: "broken", KTY: jwkset.KtyRSA, ALG: jwkset.AlgRS256}, err: errors.New("bad key material")})
+
+	_, err := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithPrune())
+	assert.NotNil(t, err, "err != nil")
+
+	for _, name := range []string{"stale.pem", "broken.pem", "rsa-key.pem", "ec-key.pem"} {
+		assert.FileExists(t, filepath.Join(dir, name), name+" kept")
+	}
+}
+
+func TestJWKS_Changes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "stale.pem"), []byte("test"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "ec-key.pem"), []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		want Changes
+	}{
+		{name: "first write", want: Changes{Added: []string{"rsa-key"}, Changed: []string{"ec-key"}, Removed: []string{filepath.Join(dir, "stale.pem")}}},
+		{name: "no changes", want: Changes{}},
+	}
+	for _, tt := range tests {
+		j := testJWKS(t)
+		_, err := j.WriteKeys(context.Background(), "{{ .KeyID }}.pem", dir, WithPrune())
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, j.Changes(), tt.name+": tt.want == j.Changes()")
+		assert.Equal(t, slices.Concat(tt.want.Added, tt.want.Changed), j.ChangedKeys(), tt.name+": j.ChangedKeys() matches j.Changes()")
+	}
+}
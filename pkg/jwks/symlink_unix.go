@@ -0,0 +1,28 @@
+//go:build !windows
+
+package jwks
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// swaplink atomically points the symlink at link to target by renaming
+// a new symlink over it
+func swaplink(target, link string) error {
+	tmp := filepath.Join(filepath.Dir(link), ".tmp-"+filepath.Base(link))
+	if err := os.Remove(tmp); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,8 @@
+package jwks
+
+import "errors"
+
+// swaplink is not supported on windows
+func swaplink(target, link string) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,68 @@
+package jwks
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Textfile returns metrics about the keys of the JWKS in the Prometheus
+// text exposition format for the node_exporter textfile collector, with
+// "now" used as the time each key was last seen. If warnWithin is set,
+// keys with an x5c certificate that expires within that window are
+// flagged.
+func (j *JWKS) Textfile(now time.Time, warnWithin time.Duration) []byte {
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_keys Number of keys in the JWKS.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_keys gauge")
+	fmt.Fprintf(buf, "jwks_to_pem_keys %d\n", len(j.keyset))
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_key_last_seen_timestamp_seconds Time the key was last seen in the JWKS.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_key_last_seen_timestamp_seconds gauge")
+	for _, jwk := range j.keyset {
+		fmt.Fprintf(buf, "jwks_to_pem_key_last_seen_timestamp_seconds{%s} %d\n", textfilelabels(jwk), now.Unix())
+	}
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_key_expiry_timestamp_seconds Expiry of the x5c certificate of the key.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_key_expiry_timestamp_seconds gauge")
+	for _, jwk := range j.keyset {
+		if notAfter, ok := jwk.NotAfter(); ok {
+			fmt.Fprintf(buf, "jwks_to_pem_key_expiry_timestamp_seconds{%s} %d\n", textfilelabels(jwk), notAfter.Unix())
+		}
+	}
+
+	if warnWithin > 0 {
+		fmt.Fprintln(buf, "# HELP jwks_to_pem_key_near_expiry Whether the x5c certificate of the key expires within the warning window.")
+		fmt.Fprintln(buf, "# TYPE jwks_to_pem_key_near_expiry gauge")
+		for _, jwk := range j.keyset {
+			if notAfter, ok := jwk.NotAfter(); ok {
+				near := 0
+				if notAfter.Before(now.Add(warnWithin)) {
+					near = 1
+				}
+				fmt.Fprintf(buf, "jwks_to_pem_key_near_expiry{%s} %d\n", textfilelabels(jwk), near)
+			}
+		}
+	}
+
+	return buf.Bytes()
+}
+
+// WriteTextfile writes metrics about the keys of the JWKS to "name" for
+// the node_exporter textfile collector
+func (j *JWKS) WriteTextfile(name string, warnWithin time.Duration) error {
+	if err := writefile(name, "", j.Textfile(time.Now(), warnWithin), new(writeOptions)); err != nil {
+		return &WriteError{Message: "writing textfile failed", Err: err}
+	}
+
+	return nil
+}
+
+var textfilereplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// textfilelabels returns the labels identifying a key
+func textfilelabels(jwk *JWK) string {
+	return fmt.Sprintf("kid=\"%s\",alg=\"%s\"", textfilereplacer.Replace(jwk.KID()), textfilereplacer.Replace(jwk.ALG()))
+}
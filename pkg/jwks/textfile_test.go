@@ -0,0 +1,100 @@
+package jwks
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertJWK returns an ES256 JWK with a self-signed x5c certificate
+// valid between notBefore and notAfter
+func testCertJWK(t *testing.T, kid string, notBefore, notAfter time.Time) *JWK {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: kid},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := jwkset.NewJWKFromKey(&priv.PublicKey, jwkset.JWKOptions{Metadata: jwkset.JWKMetadataOptions{KID: kid, ALG: jwkset.AlgES256}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := key.Marshal()
+	m.X5C = []string{base64.StdEncoding.EncodeToString(der)}
+
+	return &JWK{key: key, marshal: m}
+}
+
+func TestJWKS_Textfile(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+
+	// add a key with a certificate chain
+	j := testJWKS(t)
+	j.keyset = append(j.keyset, testCertJWK(t, "x5c-key", now, time.Unix(1800000000, 0)))
+
+	got := string(j.Textfile(now, time.Hour*24*365*4))
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "key count", want: "jwks_to_pem_keys 3\n"},
+		{name: "last seen", want: `jwks_to_pem_key_last_seen_timestamp_seconds{kid="rsa-key",alg="RS256"} 1700000000` + "\n"},
+		{name: "expiry", want: `jwks_to_pem_key_expiry_timestamp_seconds{kid="x5c-key",alg="ES256"} 1800000000` + "\n"},
+		{name: "near expiry", want: `jwks_to_pem_key_near_expiry{kid="x5c-key",alg="ES256"} 1` + "\n"},
+	}
+	for _, tt := range tests {
+		assert.True(t, strings.Contains(got, tt.want), tt.name+": output contains tt.want")
+	}
+
+	assert.NotContains(t, got, `jwks_to_pem_key_expiry_timestamp_seconds{kid="rsa-key"`, "no expiry without x5c")
+}
+
+func TestJWKS_ExpiringKeys(t *testing.T) {
+	now := time.Now()
+	j := &JWKS{keyset: []*JWK{
+		testCertJWK(t, "expired", now.Add(-time.Hour*48), now.Add(-time.Hour)),
+		testCertJWK(t, "soon", now.Add(-time.Hour*48), now.Add(time.Hour*24)),
+		testCertJWK(t, "later", now.Add(-time.Hour*48), now.Add(time.Hour*24*60)),
+	}}
+	j.keyset = append(j.keyset, testJWKS(t).keyset...)
+
+	tests := []struct {
+		name   string
+		within time.Duration
+		want   []string
+	}{
+		{name: "already expired", within: 0, want: []string{"expired"}},
+		{name: "within a week", within: time.Hour * 24 * 7, want: []string{"expired", "soon"}},
+		{name: "within a year", within: time.Hour * 24 * 365, want: []string{"expired", "soon", "later"}},
+	}
+	for _, tt := range tests {
+		got := make([]string, 0)
+		for _, k := range j.ExpiringKeys(now, tt.within) {
+			got = append(got, k.KeyID)
+		}
+
+		assert.Equal(t, tt.want, got, tt.name+": tt.want == got")
+	}
+}
@@ -0,0 +1,139 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// Token is a JWT that has been verified against a JWKS
+type Token struct {
+	KeyID  string
+	ALG    string
+	Header json.RawMessage
+	Claims json.RawMessage
+}
+
+// VerifyToken finds the key matching the "kid" of the JWT and verifies
+// its signature, returning the decoded header and claims on success
+func (j *JWKS) VerifyToken(token string) (*Token, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: expected 3 parts but got %d", ErrInvalidToken, len(parts))
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrInvalidToken, err)
+	}
+
+	claims, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: claims: %w", ErrInvalidToken, err)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: signature: %w", ErrInvalidToken, err)
+	}
+
+	var h struct {
+		ALG string `json:"alg"`
+		KID string `json:"kid"`
+	}
+	if err := json.Unmarshal(header, &h); err != nil {
+		return nil, fmt.Errorf("%w: header: %w", ErrInvalidToken, err)
+	}
+
+	t := &Token{KeyID: h.KID, ALG: h.ALG, Header: header, Claims: claims}
+
+	// find the matching key
+	var jwk *JWK
+	for _, k := range j.keyset {
+		if k.KID() == h.KID {
+			jwk = k
+			break
+		}
+	}
+	if jwk == nil {
+		return t, fmt.Errorf("%w: kid %q", ErrKeyNotFound, h.KID)
+	}
+
+	// the token must not choose a different algorithm to the key
+	if jwk.ALG() != "" && jwk.ALG() != h.ALG {
+		return t, fmt.Errorf("%w: token alg %s does not match key alg %s", ErrInvalidSignature, h.ALG, jwk.ALG())
+	}
+
+	pub, err := jwk.PublicKey()
+	if err != nil {
+		return t, err
+	}
+
+	if err := verifysignature(pub, h.ALG, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return t, err
+	}
+
+	return t, nil
+}
+
+// verifysignature checks the JWS signature over "signed" using "pub"
+func verifysignature(pub crypto.PublicKey, alg string, signed, signature []byte) error {
+	// EdDSA signs the message itself rather than a digest of it
+	if alg == "EdDSA" {
+		k, ok := pub.(ed25519.PublicKey)
+		if !ok {
+			return fmt.Errorf("%w: %s requires an Ed25519 key", ErrInvalidSignature, alg)
+		}
+
+		if !ed25519.Verify(k, signed, signature) {
+			return ErrInvalidSignature
+		}
+
+		return nil
+	}
+
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(k, hash, digest, signature); err != nil {
+			return fmt.Errorf("%w: %w", ErrInvalidSignature, err)
+		}
+	case *ecdsa.PublicKey:
+		// signature is the fixed length concatenation of r and s
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if len(signature) != 2*size {
+			return fmt.Errorf("%w: invalid signature length", ErrInvalidSignature)
+		}
+
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrInvalidSignature
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnsupportedAlgorithm, alg)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"testing"
+
+	"github.com/MicahParks/jwkset"
+	"github.com/stretchr/testify/assert"
+)
+
+// testToken returns a JWKS containing the public part of "priv" and a
+// JWT signed by it
+func testToken(t *testing.T, priv crypto.Signer, kid string, alg jwkset.ALG) (*JWKS, string) {
+	t.Helper()
+
+	key, err := jwkset.NewJWKFromKey(priv.Public(), jwkset.JWKOptions{Metadata: jwkset.JWKMetadataOptions{KID: kid, ALG: alg}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	j := &JWKS{keyset: []*JWK{{key: key, marshal: key.Marshal()}}}
+
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"` + alg.String() + `","kid":"` + kid + `","typ":"JWT"}`))
+	claims := base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"test"}`))
+	digest := sha256.Sum256([]byte(header + "." + claims))
+
+	var signature []byte
+	switch k := priv.(type) {
+	case *rsa.PrivateKey:
+		signature, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, e := ecdsa.Sign(rand.Reader, k, digest[:])
+		signature, err = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...), e
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(k, []byte(header+"."+claims))
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return j, header + "." + claims + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWKS_VerifyToken(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaJWKS, rsaToken := testToken(t, rsaKey, "rsa-key", jwkset.AlgRS256)
+	ecJWKS, ecToken := testToken(t, ecKey, "ec-key", jwkset.AlgES256)
+	edJWKS, edToken := testToken(t, edKey, "ed25519-key", jwkset.AlgEdDSA)
+
+	tests := []struct {
+		name    string
+		j       *JWKS
+		token   string
+		wantErr error
+	}{
+		{name: "rsa", j: rsaJWKS, token: rsaToken, wantErr: nil},
+		{name: "ecdsa", j: ecJWKS, token: ecToken, wantErr: nil},
+		{name: "ed25519", j: edJWKS, token: edToken, wantErr: nil},
+		{name: "tampered ed25519", j: edJWKS, token: edToken[:len(edToken)-4] + "AAAA", wantErr: ErrInvalidSignature},
+		{name: "wrong issuer", j: ecJWKS, token: rsaToken, wantErr: ErrKeyNotFound},
+		{name: "tampered", j: rsaJWKS, token: rsaToken[:len(rsaToken)-4] + "AAAA", wantErr: ErrInvalidSignature},
+		{name: "not a jwt", j: rsaJWKS, token: "abc.def", wantErr: ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		_, err := tt.j.VerifyToken(tt.token)
+		if tt.wantErr == nil {
+			assert.Nil(t, err, tt.name+": err == nil")
+		} else {
+			assert.ErrorIs(t, err, tt.wantErr, tt.name+": errors.Is(err, tt.wantErr)")
+		}
+	}
+}
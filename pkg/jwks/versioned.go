@@ -0,0 +1,182 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	// versionedLink is the symlink in the output directory that points
+	// to the current version
+	versionedLink = "current"
+
+	// versionedPrefix is the prefix of each version directory
+	versionedPrefix = "keys-"
+
+	// versionedFormat is the sortable timestamp format used to name
+	// version directories
+	versionedFormat = "20060102T150405.000000000Z"
+)
+
+// WriteVersioned writes the full set of keys as per WriteKeys into a new
+// directory named "keys-<timestamp>" within output and then atomically
+// points the "current" symlink at it, so consumers reading through
+// "current" never see a partial set of keys. If the new set of keys is
+// identical to the current one the new directory is discarded. Only the
+// newest keep versions are retained. This is not supported on windows.
+func (j *JWKS) WriteVersioned(ctx context.Context, pattern, output string, keep int, opts ...WriteOption) (bool, error) {
+	link := filepath.Join(output, versionedLink)
+
+	// write keys into a new version
+	version := versionedPrefix + time.Now().UTC().Format(versionedFormat)
+	dir := filepath.Join(output, version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return false, &WriteError{Message: "could not create version directory", Err: err}
+	}
+
+	// keys that failed are reported but do not stop the others being written
+	_, keyErr := j.WriteKeys(ctx, pattern, dir, opts...)
+
+	// consumers read keys through the link rather than the version
+	for n, entry := range j.manifest {
+		if rel, err := filepath.Rel(dir, entry.File); err == nil {
+			j.manifest[n].File = filepath.Join(link, rel)
+		}
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) == 0 {
+		os.RemoveAll(dir)
+		if err == nil {
+			err = ErrWriteFailed
+		}
+
+		return false, errors.Join(keyErr, &WriteError{Message: "no keys were written to new version", Err: err})
+	}
+
+	// keep the current version if nothing changed
+	if same, err := direqual(link, dir); err != nil {
+		os.RemoveAll(dir)
+		return false, errors.Join(keyErr, &WriteError{Message: "error comparing versions", Err: err})
+	} else if same {
+		os.RemoveAll(dir)
+		j.changes.Added = nil
+		j.changes.Changed = nil
+		j.counts.Unchanged += j.counts.Written
+		j.counts.Written = 0
+		for n := range j.manifest {
+			j.manifest[n].Changed = false
+		}
+
+		return false, keyErr
+	}
+
+	// switch to the new version
+	if err := swaplink(version, link); err != nil {
+		os.RemoveAll(dir)
+		return false, errors.Join(keyErr, &WriteError{Message: "could not switch to new version", Err: err})
+	}
+
+	if err := pruneversions(output, keep); err != nil {
+		return true, errors.Join(keyErr, &WriteError{Message: "could not remove old versions", Err: err})
+	}
+
+	return true, keyErr
+}
+
+// pruneversions removes all but the newest keep version directories
+func pruneversions(output string, keep int) error {
+	entries, err := os.ReadDir(output)
+	if err != nil {
+		return err
+	}
+
+	versions := make([]string, 0)
+	for _, e := range entries {
+		if e.IsDir() && strings.HasPrefix(e.Name(), versionedPrefix) {
+			versions = append(versions, e.Name())
+		}
+	}
+
+	// names sort oldest first
+	slices.Sort(versions)
+	if keep < 1 {
+		keep = 1
+	}
+	if len(versions) <= keep {
+		return nil
+	}
+
+	errs := make([]error, 0)
+	for _, v := range versions[:len(versions)-keep] {
+		if err := os.RemoveAll(filepath.Join(output, v)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// direqual reports if the directories a and b contain the same files
+// with the same contents. A missing directory a is never equal.
+func direqual(a, b string) (bool, error) {
+	filesA, err := dirfiles(a)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+
+	filesB, err := dirfiles(b)
+	if err != nil {
+		return false, err
+	}
+
+	if len(filesA) != len(filesB) {
+		return false, nil
+	}
+
+	for name, data := range filesA {
+		if other, ok := filesB[name]; !ok || !bytes.Equal(data, other) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// dirfiles returns the contents of every file below dir by relative path
+func dirfiles(dir string) (map[string][]byte, error) {
+	// resolve symlink to the directory itself
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := make(map[string][]byte)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[rel] = data
+
+		return nil
+	})
+
+	return files, err
+}
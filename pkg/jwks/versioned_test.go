@@ -0,0 +1,66 @@
+package jwks
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJWKS_WriteVersioned(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("versioned directories are not supported on windows")
+	}
+
+	dir := t.TempDir()
+	current := filepath.Join(dir, versionedLink)
+
+	versions := func() []string {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		list := make([]string, 0)
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), versionedPrefix) {
+				list = append(list, e.Name())
+			}
+		}
+
+		return list
+	}
+
+	changed, err := testJWKS(t).WriteVersioned(context.Background(), "{{ .KeyID }}.pem", dir, 2)
+	assert.Nil(t, err, "err == nil")
+	assert.True(t, changed, "first write changed")
+	assert.FileExists(t, filepath.Join(current, "rsa-key.pem"), "key readable through current")
+	assert.Len(t, versions(), 1, "one version")
+
+	changed, err = testJWKS(t).WriteVersioned(context.Background(), "{{ .KeyID }}.pem", dir, 2)
+	assert.Nil(t, err, "err == nil")
+	assert.False(t, changed, "unchanged keys keep current version")
+	assert.Len(t, versions(), 1, "identical version discarded")
+
+	// each changed set of keys creates a new version
+	for n, pattern := range []string{"a-{{ .KeyID }}.pem", "b-{{ .KeyID }}.pem"} {
+		time.Sleep(time.Millisecond)
+
+		changed, err = testJWKS(t).WriteVersioned(context.Background(), pattern, dir, 2)
+		assert.Nil(t, err, "err == nil")
+		assert.True(t, changed, "changed keys create a new version")
+		assert.Len(t, versions(), min(n+2, 2), "old versions pruned")
+	}
+
+	target, err := os.Readlink(current)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, versions()[1], target, "current points to newest version")
+	assert.FileExists(t, filepath.Join(current, "b-rsa-key.pem"), "newest keys readable through current")
+}
@@ -0,0 +1,486 @@
+package jwks
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// maxTempTagLength limits how much of a key id is used in temporary
+// file names
+const maxTempTagLength = 64
+
+// DefaultFileMode is the mode of written files unless set by WithFileMode.
+// Public keys are not secret so they are readable by everyone.
+const DefaultFileMode fs.FileMode = 0644
+
+// WriteOption configures how keys are written by WriteKeys
+type WriteOption func(*writeOptions)
+
+type writeOptions struct {
+	verify             []string
+	matchDirOwner      bool
+	semanticCompare    bool
+	patterns           map[string]string
+	fingerprintComment bool
+	splitByAlg         bool
+	splitAlgs          []string
+	followSymlinks     bool
+	algMap             map[string]string
+	slots              int
+	prune              bool
+	pruneExclude       []string
+	allOrNothing       bool
+	kidHash            bool
+	use                string
+	format             string
+	pemType            string
+	fileMode           fs.FileMode
+	preferX5C          bool
+	verifyX5C          bool
+	strict             bool
+	dryRun             bool
+	mkdir              bool
+	logger             *slog.Logger
+
+	// owner is resolved from the output directory by WriteKeys
+	owner *fileowner
+
+	// ctx is set by WriteKeys so that verification can be cancelled
+	ctx context.Context
+}
+
+type fileowner struct {
+	uid int
+	gid int
+}
+
+// WithVerifyCommand runs the provided command against each key before
+// it is moved into place, with the path of the key appended as the final
+// argument. A key that fails verification is not written.
+func WithVerifyCommand(command []string) WriteOption {
+	return func(o *writeOptions) {
+		if len(command) > 0 {
+			o.verify = command
+		}
+	}
+}
+
+// WithMatchDirOwner sets the owner of each written key to match the
+// owner of the output directory. This is a no-op on windows.
+func WithMatchDirOwner() WriteOption {
+	return func(o *writeOptions) {
+		o.matchDirOwner = true
+	}
+}
+
+// WithSemanticCompare compares the public key in existing files with
+// each JWK rather than comparing the files byte for byte, so cosmetic
+// differences in PEM formatting do not cause a rewrite
+func WithSemanticCompare() WriteOption {
+	return func(o *writeOptions) {
+		o.semanticCompare = true
+	}
+}
+
+// WithKeyTypePattern uses pattern instead of the pattern provided to
+// WriteKeys for keys with a key type (kty) of RSA, EC or OKP. An empty
+// pattern is ignored.
+func WithKeyTypePattern(kty, pattern string) WriteOption {
+	return func(o *writeOptions) {
+		if pattern == "" {
+			return
+		}
+
+		if o.patterns == nil {
+			o.patterns = make(map[string]string)
+		}
+		o.patterns[kty] = pattern
+	}
+}
+
+// WithFingerprintComment adds a comment line containing the SHA-256
+// fingerprint of the key before each PEM block
+func WithFingerprintComment() WriteOption {
+	return func(o *writeOptions) {
+		o.fingerprintComment = true
+	}
+}
+
+// WithSplitByAlg writes keys into a sub-directory of the output
+// directory named after the algorithm of the key. If any algorithms are
+// provided only keys using those algorithms are split out.
+func WithSplitByAlg(algs ...string) WriteOption {
+	return func(o *writeOptions) {
+		o.splitByAlg = true
+		o.splitAlgs = algs
+	}
+}
+
+// WithAlgorithmMap renames the algorithm of each key as exposed to
+// templates, for example "ES256" to "ecdsa-sha256". Algorithms not in
+// the map are left as is and the conversion of keys is not affected.
+func WithAlgorithmMap(m map[string]string) WriteOption {
+	return func(o *writeOptions) {
+		o.algMap = m
+	}
+}
+
+// WithSlots writes only the newest n keys, ordered by the notBefore of
+// their x5c certificate or otherwise by fetch order, with the Index
+// available to the pattern being the slot from 0 (newest) to n-1
+func WithSlots(n int) WriteOption {
+	return func(o *writeOptions) {
+		o.slots = n
+	}
+}
+
+// WithStrict treats keys of an unsupported type, algorithm or curve as
+// errors. By default they are logged and skipped.
+func WithStrict() WriteOption {
+	return func(o *writeOptions) {
+		o.strict = true
+	}
+}
+
+// WithMkdir creates the output directory, and any directories within it
+// from the naming pattern, if they do not exist. By default WriteKeys
+// returns ErrNoOutputDir if the output directory is missing.
+func WithMkdir() WriteOption {
+	return func(o *writeOptions) {
+		o.mkdir = true
+	}
+}
+
+// WithDryRun compares each key against the output directory and logs
+// whether it would be written, skipped or pruned, without writing or
+// removing any files. WriteKeys still reports the keys as changed.
+func WithDryRun() WriteOption {
+	return func(o *writeOptions) {
+		o.dryRun = true
+	}
+}
+
+// WithAllOrNothing writes no keys at all if any key in the JWKS could
+// not be converted, rather than writing the keys that could be
+func WithAllOrNothing() WriteOption {
+	return func(o *writeOptions) {
+		o.allOrNothing = true
+	}
+}
+
+// WithKIDHash replaces the KeyID available to the pattern with the first
+// 16 hex characters of the SHA-256 hash of the key id, which is always
+// safe to use in a file name
+func WithKIDHash() WriteOption {
+	return func(o *writeOptions) {
+		o.kidHash = true
+	}
+}
+
+// WithFileMode sets the permissions of written files, which otherwise
+// use DefaultFileMode
+func WithFileMode(mode fs.FileMode) WriteOption {
+	return func(o *writeOptions) {
+		o.fileMode = mode
+	}
+}
+
+// WithPreferX5C writes the x5c certificate chain of a key as PEM encoded
+// certificates in place of its public key when using FormatPEM. Keys
+// without an x5c chain are written as a public key as usual.
+func WithPreferX5C() WriteOption {
+	return func(o *writeOptions) {
+		o.preferX5C = true
+	}
+}
+
+// WithVerifyX5C refuses to write a key whose x5c leaf certificate holds a
+// different public key to the JWK, returning ErrX5CMismatch
+func WithVerifyX5C() WriteOption {
+	return func(o *writeOptions) {
+		o.verifyX5C = true
+	}
+}
+
+// WithUse only writes keys whose "use" matches, such as "sig" or "enc".
+// Keys that do not declare a use are always written.
+func WithUse(use string) WriteOption {
+	return func(o *writeOptions) {
+		o.use = use
+	}
+}
+
+// WithFormat writes keys in format, which is one of FormatPEM (the
+// default), FormatDER, FormatJWK or FormatSSH
+func WithFormat(format string) WriteOption {
+	return func(o *writeOptions) {
+		o.format = format
+	}
+}
+
+// WithPEMType encodes keys written in PEM format using pemType, which is
+// PEMTypePKIX (the default) or PEMTypePKCS1. Writing any key other than
+// an RSA key with PEMTypePKCS1 returns ErrPKCS1NotRSA.
+func WithPEMType(pemType string) WriteOption {
+	return func(o *writeOptions) {
+		o.pemType = pemType
+	}
+}
+
+// WithLogger logs the decision made for each key to logger rather than
+// the default logger of the slog package
+func WithLogger(logger *slog.Logger) WriteOption {
+	return func(o *writeOptions) {
+		o.logger = logger
+	}
+}
+
+// log returns the logger set by WithLogger or the default logger
+func (o *writeOptions) log() *slog.Logger {
+	if o.logger == nil {
+		return slog.Default()
+	}
+
+	return o.logger
+}
+
+// keyerror logs err for jwk at debug level and returns it, noting the
+// key that failed so it can be reported even without a key ID
+func (o *writeOptions) keyerror(jwk *JWK, err error) error {
+	o.log().Debug("key could not be written", "kid", jwk.KID(), "alg", jwk.ALG(), "error", err)
+
+	var we *WriteError
+	if errors.As(err, &we) && we.key == nil {
+		we.key = jwk
+	}
+
+	return err
+}
+
+// pem reports if keys are written in PEM format
+func (o *writeOptions) pem() bool {
+	return o.format == "" || o.format == FormatPEM
+}
+
+// comments reports if the format allows "#" comment lines
+func (o *writeOptions) comments() bool {
+	return o.pem() || o.format == FormatSSH
+}
+
+// kidname returns the key id as presented to the pattern
+func (o *writeOptions) kidname(kid string) string {
+	if !o.kidHash {
+		return kidreplacer.Replace(kid)
+	}
+
+	return kidhash(kid)
+}
+
+// kidreplacer replaces path separators so a key id cannot add
+// directories to a file name
+var kidreplacer = strings.NewReplacer("/", "_", "\\", "_")
+
+// kidhash returns a short deterministic hash of kid
+func kidhash(kid string) string {
+	sum := sha256.Sum256([]byte(kid))
+
+	return hex.EncodeToString(sum[:])[:16]
+}
+
+// patterndata returns the data for the naming pattern of the key at
+// index n
+func (o *writeOptions) patterndata(n int, jwk *JWK) PatternData {
+	// fall back to the index so keys without an id get a stable name
+	kid := strconv.Itoa(n)
+	if jwk.KID() != "" {
+		kid = o.kidname(jwk.KID())
+	}
+
+	// the key has already been encoded so this cannot fail
+	thumbprint, _ := jwk.Thumbprint()
+
+	return PatternData{
+		Index:      n,
+		KeyID:      kid,
+		ALG:        o.algname(jwk.ALG()),
+		KTY:        jwk.KTY(),
+		Use:        jwk.USE(),
+		Thumbprint: thumbprint,
+	}
+}
+
+// algname returns the presented name of alg
+func (o *writeOptions) algname(alg string) string {
+	if name, ok := o.algMap[alg]; ok {
+		return name
+	}
+
+	return alg
+}
+
+// splitdir returns the directory to write a key using alg to
+func (o *writeOptions) splitdir(output, alg string) string {
+	if !o.splitByAlg || alg == "" {
+		return output
+	}
+
+	if len(o.splitAlgs) > 0 && !slices.Contains(o.splitAlgs, alg) {
+		return output
+	}
+
+	return filepath.Join(output, alg)
+}
+
+// WithFollowSymlinks writes through to the target of an existing symlink
+// rather than replacing the symlink with a regular file
+func WithFollowSymlinks() WriteOption {
+	return func(o *writeOptions) {
+		o.followSymlinks = true
+	}
+}
+
+// resolvelink returns the file that name points to if it is a symlink
+func resolvelink(name string) (string, error) {
+	fi, err := os.Lstat(name)
+	if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		return name, nil
+	}
+
+	resolved, err := filepath.EvalSymlinks(name)
+	if err == nil {
+		return resolved, nil
+	}
+
+	// the target of a dangling symlink does not exist yet
+	if !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+
+	target, err := os.Readlink(name)
+	if err != nil {
+		return "", err
+	}
+
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(name), target)
+	}
+
+	return target, nil
+}
+
+func verify(ctx context.Context, command []string, name string) error {
+	// append path to a copy of the provided arguments
+	args := append(append([]string{}, command[1:]...), name)
+
+	out, err := exec.CommandContext(ctx, command[0], args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%w: %s: %s", ErrVerifyFailed, err, bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// writefile atomically writes data to name via a temporary file in the
+// same directory. The temporary file name includes tag, or the base name
+// of the file if tag is empty, so an interrupted write can be traced.
+func writefile(name, tag string, data []byte, options *writeOptions) error {
+	if tag == "" {
+		tag = filepath.Base(name)
+	}
+
+	// write to the target of a symlink so the temp file is on the same filesystem
+	if options.followSymlinks {
+		resolved, err := resolvelink(name)
+		if err != nil {
+			return err
+		}
+		name = resolved
+	}
+
+	// create temp file
+	f, err := os.CreateTemp(filepath.Dir(name), ".tmp-"+tempsafe(tag)+"-*")
+	if err != nil {
+		return err
+	}
+
+	// save temp file name
+	tempName := f.Name()
+
+	// close temp file and remove once done
+	defer func() {
+		f.Close()
+		os.Remove(tempName)
+	}()
+
+	// write data to temp file
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+
+	// close temp file
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// only widen permissions once the content is in place
+	mode := options.fileMode
+	if mode == 0 {
+		mode = DefaultFileMode
+	}
+	if err := os.Chmod(tempName, mode); err != nil {
+		return err
+	}
+
+	// set owner
+	if options.owner != nil {
+		if err := os.Chown(tempName, options.owner.uid, options.owner.gid); err != nil {
+			return err
+		}
+	}
+
+	// verify key before it is moved into place
+	if options.verify != nil {
+		ctx := options.ctx
+		if ctx == nil {
+			ctx = context.Background()
+		}
+
+		if err := verify(ctx, options.verify, tempName); err != nil {
+			return err
+		}
+	}
+
+	// move into place
+	return os.Rename(tempName, name)
+}
+
+// tempsafe replaces any characters in s that are unsafe in a file name
+// and limits its length
+func tempsafe(s string) string {
+	s = strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+
+		return '_'
+	}, s)
+
+	if len(s) > maxTempTagLength {
+		return s[:maxTempTagLength]
+	}
+
+	return s
+}
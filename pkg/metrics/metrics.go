@@ -0,0 +1,144 @@
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the fetch latency
+// histogram
+var DefaultBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Metrics holds the counters, gauges and histogram exposed by ServeHTTP.
+// It is safe for concurrent use.
+type Metrics struct {
+	mu sync.Mutex
+
+	fetches       uint64
+	fetchFailures uint64
+	reloads       uint64
+	reloadFailed  uint64
+	runs          uint64
+	runFailures   uint64
+	runTimeouts   uint64
+	keys          int
+
+	buckets []float64
+	counts  []uint64
+	sum     float64
+}
+
+// New returns a Metrics using DefaultBuckets for fetch latency
+func New() *Metrics {
+	return &Metrics{
+		buckets: DefaultBuckets,
+		counts:  make([]uint64, len(DefaultBuckets)),
+	}
+}
+
+// ObserveFetch records a fetch of the JWKS that took d and failed if err
+// is not nil
+func (m *Metrics) ObserveFetch(d time.Duration, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.fetches++
+	if err != nil {
+		m.fetchFailures++
+	}
+
+	seconds := d.Seconds()
+	m.sum += seconds
+	for n, le := range m.buckets {
+		if seconds <= le {
+			m.counts[n]++
+		}
+	}
+}
+
+// ObserveReload records a reload attempt that failed if err is not nil
+func (m *Metrics) ObserveReload(err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.reloads++
+	if err != nil {
+		m.reloadFailed++
+	}
+}
+
+// ObserveRun records a complete run that failed if err is not nil, or
+// that was cancelled for taking too long if timedOut is set
+func (m *Metrics) ObserveRun(err error, timedOut bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.runs++
+	switch {
+	case timedOut:
+		m.runTimeouts++
+	case err != nil:
+		m.runFailures++
+	}
+}
+
+// SetKeys sets the number of keys written or already up to date after the
+// last JWKS fetched
+func (m *Metrics) SetKeys(n int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.keys = n
+}
+
+// Text returns the metrics in the Prometheus text exposition format
+func (m *Metrics) Text() []byte {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	buf := new(bytes.Buffer)
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_fetches_total Number of fetches of the JWKS by result.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_fetches_total counter")
+	fmt.Fprintf(buf, "jwks_to_pem_fetches_total{result=\"success\"} %d\n", m.fetches-m.fetchFailures)
+	fmt.Fprintf(buf, "jwks_to_pem_fetches_total{result=\"failure\"} %d\n", m.fetchFailures)
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_reloads_total Number of reload attempts.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_reloads_total counter")
+	fmt.Fprintf(buf, "jwks_to_pem_reloads_total %d\n", m.reloads)
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_reload_failures_total Number of failed reload attempts.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_reload_failures_total counter")
+	fmt.Fprintf(buf, "jwks_to_pem_reload_failures_total %d\n", m.reloadFailed)
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_runs_total Number of runs of the fetch, write and reload cycle by result.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_runs_total counter")
+	fmt.Fprintf(buf, "jwks_to_pem_runs_total{result=\"success\"} %d\n", m.runs-m.runFailures-m.runTimeouts)
+	fmt.Fprintf(buf, "jwks_to_pem_runs_total{result=\"failure\"} %d\n", m.runFailures)
+	fmt.Fprintf(buf, "jwks_to_pem_runs_total{result=\"timeout\"} %d\n", m.runTimeouts)
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_keys_written Number of keys that could be written from the last JWKS fetched.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_keys_written gauge")
+	fmt.Fprintf(buf, "jwks_to_pem_keys_written %d\n", m.keys)
+
+	fmt.Fprintln(buf, "# HELP jwks_to_pem_fetch_duration_seconds Time taken to fetch the JWKS.")
+	fmt.Fprintln(buf, "# TYPE jwks_to_pem_fetch_duration_seconds histogram")
+	for n, le := range m.buckets {
+		fmt.Fprintf(buf, "jwks_to_pem_fetch_duration_seconds_bucket{le=\"%s\"} %d\n", strconv.FormatFloat(le, 'g', -1, 64), m.counts[n])
+	}
+	fmt.Fprintf(buf, "jwks_to_pem_fetch_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.fetches)
+	fmt.Fprintf(buf, "jwks_to_pem_fetch_duration_seconds_sum %s\n", strconv.FormatFloat(m.sum, 'g', -1, 64))
+	fmt.Fprintf(buf, "jwks_to_pem_fetch_duration_seconds_count %d\n", m.fetches)
+
+	return buf.Bytes()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write(m.Text())
+}
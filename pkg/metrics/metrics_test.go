@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_ServeHTTP(t *testing.T) {
+	m := New()
+	m.ObserveFetch(time.Millisecond*20, nil)
+	m.ObserveFetch(time.Second*3, errors.New("failed"))
+	m.ObserveReload(nil)
+	m.ObserveReload(errors.New("failed"))
+	m.ObserveRun(nil, false)
+	m.ObserveRun(nil, false)
+	m.ObserveRun(errors.New("failed"), false)
+	m.ObserveRun(errors.New("timed out"), true)
+	m.SetKeys(2)
+
+	rec := httptest.NewRecorder()
+	m.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{name: "successful fetches", want: `jwks_to_pem_fetches_total{result="success"} 1` + "\n"},
+		{name: "failed fetches", want: `jwks_to_pem_fetches_total{result="failure"} 1` + "\n"},
+		{name: "reloads", want: "jwks_to_pem_reloads_total 2\n"},
+		{name: "reload failures", want: "jwks_to_pem_reload_failures_total 1\n"},
+		{name: "successful runs", want: `jwks_to_pem_runs_total{result="success"} 2` + "\n"},
+		{name: "failed runs", want: `jwks_to_pem_runs_total{result="failure"} 1` + "\n"},
+		{name: "timed out runs", want: `jwks_to_pem_runs_total{result="timeout"} 1` + "\n"},
+		{name: "keys", want: "jwks_to_pem_keys_written 2\n"},
+		{name: "fast bucket", want: `jwks_to_pem_fetch_duration_seconds_bucket{le="0.05"} 1` + "\n"},
+		{name: "slow bucket", want: `jwks_to_pem_fetch_duration_seconds_bucket{le="5"} 2` + "\n"},
+		{name: "all buckets", want: `jwks_to_pem_fetch_duration_seconds_bucket{le="+Inf"} 2` + "\n"},
+		{name: "count", want: "jwks_to_pem_fetch_duration_seconds_count 2\n"},
+	}
+	for _, tt := range tests {
+		assert.Contains(t, string(body), tt.want, tt.name)
+	}
+	assert.Contains(t, rec.Header().Get("Content-Type"), "text/plain", "content type")
+}
@@ -0,0 +1,59 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Summary describes a change to the written keys
+type Summary struct {
+	Changed   []string  `json:"changed"`
+	Timestamp time.Time `json:"timestamp"`
+	Issuer    string    `json:"issuer"`
+}
+
+// Notifier sends a JSON summary of changes to a URL
+type Notifier struct {
+	url    string
+	client *http.Client
+}
+
+func NewNotifier(url string, timeout time.Duration) (*Notifier, error) {
+	return &Notifier{url: url, client: &http.Client{Timeout: timeout}}, nil
+}
+
+func (n *Notifier) Info() string {
+	return n.url
+}
+
+// Notify POSTs the summary as JSON to the configured URL
+func (n *Notifier) Notify(summary Summary) error {
+	b, err := json.Marshal(summary)
+	if err != nil {
+		return fmt.Errorf("could not encode summary: %w", err)
+	}
+
+	// set up request
+	req, err := http.NewRequest(http.MethodPost, n.url, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	// do request
+	res, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error during request: %w", err)
+	}
+	defer res.Body.Close()
+
+	// check response
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("bad response code: %d", res.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package notify
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotifier_Notify(t *testing.T) {
+	var got Summary
+	var contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&got)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer ts.Close()
+
+	n, err := NewNotifier(ts.URL, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Summary{Changed: []string{"k1", "k2"}, Timestamp: time.Now().UTC().Truncate(time.Second), Issuer: "https://example.com/jwks.json"}
+	assert.Nil(t, n.Notify(want), "err == nil")
+	assert.Equal(t, "application/json", contentType, "content type")
+	assert.Equal(t, want, got, "summary received")
+}
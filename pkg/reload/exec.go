@@ -0,0 +1,42 @@
+package reload
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// ExecReloader runs a command such as "nginx -s reload" to reload
+type ExecReloader struct {
+	command []string
+}
+
+// NewExecReloader runs the command, which is split into arguments on
+// whitespace without any shell expansion
+func NewExecReloader(command string) (*ExecReloader, error) {
+	args := strings.Fields(command)
+	if len(args) == 0 {
+		return nil, fmt.Errorf("no reload command provided")
+	}
+
+	return &ExecReloader{command: args}, nil
+}
+
+func (r *ExecReloader) Info() string {
+	return strings.Join(r.command, " ")
+}
+
+func (r *ExecReloader) Reload(ctx context.Context) error {
+	out, err := exec.CommandContext(ctx, r.command[0], r.command[1:]...).CombinedOutput()
+	if err != nil {
+		if out = bytes.TrimSpace(out); len(out) > 0 {
+			return fmt.Errorf("reload command failed: %w: %s", err, out)
+		}
+
+		return fmt.Errorf("reload command failed: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExecReloader_Reload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("reload commands are not tested on windows")
+	}
+
+	script := filepath.Join(t.TempDir(), "reload.sh")
+	if err := os.WriteFile(script, []byte("echo 'bad config' >&2\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		wantErr string
+	}{
+		{name: "success", command: "true", wantErr: ""},
+		{name: "failure with output", command: "sh " + script, wantErr: "bad config"},
+		{name: "missing command", command: "jwks-to-pem-missing-command", wantErr: "executable file not found"},
+	}
+	for _, tt := range tests {
+		r, err := NewExecReloader(tt.command)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, tt.command, r.Info(), tt.name+": tt.command == r.Info()")
+
+		err = r.Reload(context.Background())
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.name+": error contains output")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+	}
+
+	_, err := NewExecReloader(" ")
+	assert.NotNil(t, err, "empty command")
+}
@@ -0,0 +1,185 @@
+package reload
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// serviceAccountDir is where the in-cluster service account credentials
+// are mounted
+const serviceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// restartedAtAnnotation is the pod template annotation set by
+// "kubectl rollout restart"
+const restartedAtAnnotation = "kubectl.kubernetes.io/restartedAt"
+
+// K8sRolloutReloader performs the equivalent of "kubectl rollout restart"
+// on a Deployment or StatefulSet using the in-cluster service account
+type K8sRolloutReloader struct {
+	kind      string
+	namespace string
+	name      string
+
+	server  string
+	account string
+	client  *http.Client
+
+	// status is the rollout status as of the last reload
+	status string
+}
+
+// K8sRolloutReloaderOption configures optional behaviour of a
+// K8sRolloutReloader
+type K8sRolloutReloaderOption func(*K8sRolloutReloader)
+
+// WithK8sServer sets the URL of the Kubernetes API server rather than
+// using the in-cluster KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT
+func WithK8sServer(server string) K8sRolloutReloaderOption {
+	return func(r *K8sRolloutReloader) {
+		r.server = server
+	}
+}
+
+// WithK8sServiceAccountDir reads the token, CA certificate and namespace
+// of the service account from dir
+func WithK8sServiceAccountDir(dir string) K8sRolloutReloaderOption {
+	return func(r *K8sRolloutReloader) {
+		r.account = dir
+	}
+}
+
+// NewK8sRolloutReloader restarts the workload of kind "deployment" or
+// "statefulset" named by target as "namespace/name". When the namespace
+// is omitted the namespace of the service account is used.
+func NewK8sRolloutReloader(kind, target string, opts ...K8sRolloutReloaderOption) (*K8sRolloutReloader, error) {
+	switch kind {
+	case "deployment", "statefulset":
+	default:
+		return nil, fmt.Errorf("unsupported workload kind: %q", kind)
+	}
+
+	r := &K8sRolloutReloader{kind: kind, account: serviceAccountDir}
+	for _, o := range opts {
+		o(r)
+	}
+
+	// split target into namespace and name
+	namespace, name, found := strings.Cut(target, "/")
+	if !found {
+		b, err := os.ReadFile(filepath.Join(r.account, "namespace"))
+		if err != nil {
+			return nil, fmt.Errorf("no namespace provided and could not read service account namespace: %w", err)
+		}
+		namespace, name = strings.TrimSpace(string(b)), target
+	}
+	if namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid %s: %q", kind, target)
+	}
+	r.namespace, r.name = namespace, name
+
+	// locate API server
+	if r.server == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, fmt.Errorf("not running in a Kubernetes cluster")
+		}
+		r.server = "https://" + net.JoinHostPort(host, port)
+	}
+
+	// trust the cluster CA if available
+	r.client = http.DefaultClient
+	if ca, err := os.ReadFile(filepath.Join(r.account, "ca.crt")); err == nil {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("could not load service account CA certificate")
+		}
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		r.client = &http.Client{Transport: transport}
+	}
+
+	return r, nil
+}
+
+func (r *K8sRolloutReloader) Info() string {
+	info := fmt.Sprintf("%s %s/%s", r.kind, r.namespace, r.name)
+	if r.status != "" {
+		info += " (" + r.status + ")"
+	}
+
+	return info
+}
+
+func (r *K8sRolloutReloader) Reload(ctx context.Context) error {
+	// read token each time as it is rotated by the kubelet
+	token, err := os.ReadFile(filepath.Join(r.account, "token"))
+	if err != nil {
+		return fmt.Errorf("could not read service account token: %w", err)
+	}
+
+	// update restart annotation of the pod template
+	patch, err := json.Marshal(map[string]any{
+		"spec": map[string]any{
+			"template": map[string]any{
+				"metadata": map[string]any{
+					"annotations": map[string]string{
+						restartedAtAnnotation: time.Now().Format(time.RFC3339),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("could not build patch: %w", err)
+	}
+
+	// set up request
+	url := fmt.Sprintf("%s/apis/apps/v1/namespaces/%s/%ss/%s", r.server, r.namespace, r.kind, r.name)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, url, bytes.NewReader(patch))
+	if err != nil {
+		return fmt.Errorf("could not build request: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/strategic-merge-patch+json")
+
+	// do request
+	res, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error during request: %w", err)
+	}
+	defer res.Body.Close()
+
+	// check response
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("bad response code: %d", res.StatusCode)
+	}
+
+	// record rollout status of the patched workload
+	var workload struct {
+		Metadata struct {
+			Generation int64 `json:"generation"`
+		} `json:"metadata"`
+		Status struct {
+			ObservedGeneration int64 `json:"observedGeneration"`
+			Replicas           int32 `json:"replicas"`
+			UpdatedReplicas    int32 `json:"updatedReplicas"`
+		} `json:"status"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&workload); err == nil {
+		r.status = fmt.Sprintf("restarted generation %d, %d of %d replicas updated",
+			workload.Metadata.Generation, workload.Status.UpdatedReplicas, workload.Status.Replicas)
+	}
+
+	return nil
+}
@@ -0,0 +1,63 @@
+package reload
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestK8sRolloutReloader(t *testing.T) {
+	account := t.TempDir()
+	for name, content := range map[string]string{"token": "secret\n", "namespace": "default\n"} {
+		if err := os.WriteFile(filepath.Join(account, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var gotPath, gotAuth, gotType string
+	var gotPatch map[string]any
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath, gotAuth, gotType = r.URL.Path, r.Header.Get("Authorization"), r.Header.Get("Content-Type")
+		b, _ := io.ReadAll(r.Body)
+		json.Unmarshal(b, &gotPatch)
+		w.Write([]byte(`{"metadata":{"generation":4},"status":{"observedGeneration":3,"replicas":2,"updatedReplicas":0}}`))
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		kind     string
+		target   string
+		wantPath string
+		wantErr  bool
+	}{
+		{name: "deployment", kind: "deployment", target: "web/api", wantPath: "/apis/apps/v1/namespaces/web/deployments/api", wantErr: false},
+		{name: "default namespace", kind: "statefulset", target: "db", wantPath: "/apis/apps/v1/namespaces/default/statefulsets/db", wantErr: false},
+		{name: "bad kind", kind: "pod", target: "web/api", wantErr: true},
+		{name: "bad target", kind: "deployment", target: "web/", wantErr: true},
+	}
+	for _, tt := range tests {
+		r, err := NewK8sRolloutReloader(tt.kind, tt.target, WithK8sServer(ts.URL), WithK8sServiceAccountDir(account))
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+		assert.Nil(t, err, tt.name+": err == nil")
+
+		err = r.Reload(context.Background())
+		assert.Nil(t, err, tt.name+": reload err == nil")
+		assert.Equal(t, tt.wantPath, gotPath, tt.name+": path")
+		assert.Equal(t, "Bearer secret", gotAuth, tt.name+": token")
+		assert.Equal(t, "application/strategic-merge-patch+json", gotType, tt.name+": content type")
+		assert.Contains(t, gotPatch["spec"], "template", tt.name+": patched pod template")
+		assert.True(t, strings.HasSuffix(r.Info(), "(restarted generation 4, 0 of 2 replicas updated)"), tt.name+": status in info")
+	}
+}
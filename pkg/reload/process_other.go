@@ -0,0 +1,13 @@
+//go:build !linux
+
+package reload
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// processes is only supported on linux
+func processes() ([]process, error) {
+	return nil, fmt.Errorf("looking up processes by name is not supported on %s", runtime.GOOS)
+}
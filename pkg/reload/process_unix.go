@@ -0,0 +1,33 @@
+//go:build !windows
+
+package reload
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// alive reports whether pid refers to a running process
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// EPERM means the process exists but we may not signal it
+	err = p.Signal(syscall.Signal(0))
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// processgroup returns the process group of this process
+func processgroup() (int, error) {
+	return syscall.Getpgrp(), nil
+}
+
+// kill sends signal to target as per kill(2), where a negative target
+// is a process group
+func kill(target int, signal syscall.Signal) error {
+	return syscall.Kill(target, signal)
+}
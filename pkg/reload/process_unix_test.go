@@ -0,0 +1,92 @@
+//go:build !windows
+
+package reload
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	ossignal "os/signal"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProcessGroupReloader(t *testing.T) {
+	own := syscall.Getpgrp()
+
+	tests := []struct {
+		name       string
+		pgid       int
+		signal     syscall.Signal
+		wantTarget int
+		wantErr    bool
+	}{
+		{name: "own group", pgid: -1, wantTarget: -own, wantErr: own <= 1},
+		{name: "group", pgid: 1234, wantTarget: -1234, wantErr: false},
+		{name: "init", pgid: 1, wantErr: true},
+		{name: "zero", pgid: 0, wantErr: true},
+		{name: "negative", pgid: -5, wantErr: true},
+		{name: "terminate own group", pgid: -1, signal: syscall.SIGTERM, wantErr: true},
+		{name: "terminate group", pgid: 1234, signal: syscall.SIGTERM, wantTarget: -1234, wantErr: false},
+	}
+	for _, tt := range tests {
+		if tt.signal == 0 {
+			tt.signal = syscall.SIGHUP
+		}
+
+		got, err := NewProcessGroupReloader(tt.pgid, tt.signal)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.wantTarget, got.target(), tt.name+": tt.wantTarget == got.target()")
+		assert.Equal(t, fmt.Sprintf("PGID = %d", -tt.wantTarget), got.Info(), tt.name+": Info()")
+	}
+}
+
+func TestProcessReloader_Reload_group(t *testing.T) {
+	// a process leading its own group
+	cmd := exec.Command("sleep", "10")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewProcessGroupReloader(cmd.Process.Pid, syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, r.Reload(context.Background()), "err == nil")
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); assert.True(t, ok, "process was signalled") {
+		status := exitErr.Sys().(syscall.WaitStatus)
+		assert.Equal(t, syscall.SIGTERM, status.Signal(), "group received signal")
+	}
+}
+
+func TestProcessReloader_Reload_ownGroup(t *testing.T) {
+	if syscall.Getpgrp() <= 1 {
+		t.Skip("not in a process group that can be signalled")
+	}
+
+	// creating the reloader leaves signal handling alone
+	if _, err := NewProcessGroupReloader(-1, syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, ossignal.Ignored(syscall.SIGHUP), "SIGHUP not ignored")
+
+	// a signal ignored by default so the rest of the group is unaffected
+	r, err := NewProcessGroupReloader(-1, syscall.SIGWINCH)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, r.Reload(context.Background()), "err == nil")
+	assert.False(t, ossignal.Ignored(syscall.SIGWINCH), "SIGWINCH not ignored after reload")
+}
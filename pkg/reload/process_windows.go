@@ -0,0 +1,28 @@
+package reload
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// alive reports whether pid refers to a running process
+func alive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+
+	return true
+}
+
+// processgroup is not supported on windows
+func processgroup() (int, error) {
+	return 0, errors.ErrUnsupported
+}
+
+// kill is not supported on windows
+func kill(target int, signal syscall.Signal) error {
+	return errors.ErrUnsupported
+}
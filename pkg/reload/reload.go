@@ -1,68 +1,319 @@
 package reload
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"math/rand/v2"
 	"net"
 	"net/http"
 	"os"
+	ossignal "os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
+// pidfileRetryInterval is the base interval between attempts to read a
+// pidfile
+const pidfileRetryInterval = 50 * time.Millisecond
+
+// Reloader triggers a reload of another service. Reload gives up when ctx
+// is cancelled.
 type Reloader interface {
-	Reload() error
+	Reload(ctx context.Context) error
 	Info() string
 }
 
 type ProcessReloader struct {
-	pid    int
-	signal syscall.Signal
+	pid     int
+	signal  syscall.Signal
+	pidfile string
+	timeout time.Duration
+	name    string
+	all     bool
+	pids    []int
+	pgid    int
+
+	// own is set when pgid is the process group of this process
+	own bool
+}
+
+// ownGroupTimeout limits how long a reload of our own process group waits
+// for the signal to arrive back at this process
+const ownGroupTimeout = time.Second
+
+// process is an entry from the list of running processes
+type process struct {
+	pid     int
+	comm    string
+	cmdline []string
+}
+
+// listprocesses returns the running processes and is replaced in tests
+var listprocesses = processes
+
+// NewProcessReloaderFromPidfile looks up the process to signal from a
+// pidfile, retrying for up to timeout until the pidfile contains the pid
+// of a running process. The pidfile is read again before each reload.
+func NewProcessReloaderFromPidfile(pidfile string, signal syscall.Signal, timeout time.Duration) (*ProcessReloader, error) {
+	pid, err := readpidfile(pidfile, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcessReloader{pid: pid, signal: signal, pidfile: pidfile, timeout: timeout}, nil
+}
+
+func NewProcessReloader(pid int, signal syscall.Signal) (*ProcessReloader, error) {
+	return &ProcessReloader{pid: pid, signal: signal}, nil
+}
+
+// NewProcessGroupReloader signals every process in the process group pgid,
+// or the process group of this process if pgid is -1. When this process is
+// in the group the signal is caught while each reload is sent so that it is
+// not stopped by its own reloads, and signals that are meant to stop a
+// process are refused. Process groups are not supported on windows.
+func NewProcessGroupReloader(pgid int, signal syscall.Signal) (*ProcessReloader, error) {
+	own, err := processgroup()
+	if err != nil {
+		return nil, fmt.Errorf("process group reloads are not supported: %w", err)
+	}
+
+	if pgid == -1 {
+		pgid = own
+	}
+
+	// never signal the group of init
+	if pgid <= 1 {
+		return nil, fmt.Errorf("invalid process group: %d", pgid)
+	}
+
+	// a reload must never be a request for ourselves to exit
+	if pgid == own {
+		switch signal {
+		case syscall.SIGINT, syscall.SIGTERM, syscall.SIGQUIT, syscall.SIGKILL:
+			return nil, fmt.Errorf("refusing to send %s to our own process group", signal)
+		}
+	}
+
+	return &ProcessReloader{signal: signal, pgid: pgid, own: pgid == own}, nil
+}
+
+// reloadgroup signals the process group, catching the signal when it is
+// our own group until it has arrived so it does not stop this process
+func (r *ProcessReloader) reloadgroup(ctx context.Context) error {
+	if !r.own {
+		if err := kill(r.target(), r.signal); err != nil {
+			return fmt.Errorf("reload error: %w", err)
+		}
+
+		return nil
+	}
+
+	caught := make(chan os.Signal, 1)
+	ossignal.Notify(caught, r.signal)
+	defer ossignal.Stop(caught)
+
+	if err := kill(r.target(), r.signal); err != nil {
+		return fmt.Errorf("reload error: %w", err)
+	}
+
+	t := time.NewTimer(ownGroupTimeout)
+	defer t.Stop()
+
+	select {
+	case <-caught:
+	case <-t.C:
+	case <-ctx.Done():
+	}
+
+	return nil
+}
+
+// NewProcessReloaderFromName looks up the process to signal by its name,
+// which is matched against the command name and the first argument of its
+// command line. When more than one process matches, all of them are
+// signalled if all is true, otherwise an error is returned. The processes
+// are looked up again before each reload.
+func NewProcessReloaderFromName(name string, signal syscall.Signal, all bool) (*ProcessReloader, error) {
+	if name == "" {
+		return nil, fmt.Errorf("process name was empty")
+	}
+
+	pids, err := findpids(name, all)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ProcessReloader{pid: pids[0], signal: signal, name: name, all: all, pids: pids}, nil
+}
+
+func findpids(name string, all bool) ([]int, error) {
+	procs, err := listprocesses()
+	if err != nil {
+		return nil, fmt.Errorf("could not list processes: %w", err)
+	}
+
+	self := os.Getpid()
+	pids := make([]int, 0)
+	for _, p := range procs {
+		if p.pid == self {
+			continue
+		}
+
+		if p.comm == name || (len(p.cmdline) > 0 && filepath.Base(p.cmdline[0]) == name) {
+			pids = append(pids, p.pid)
+		}
+	}
+
+	switch {
+	case len(pids) == 0:
+		return nil, fmt.Errorf("no running process found named: %s", name)
+	case len(pids) > 1 && !all:
+		return nil, fmt.Errorf("found %d processes named %s: %v", len(pids), name, pids)
+	}
+
+	slices.Sort(pids)
+
+	return pids, nil
+}
+
+func readpidfile(pidfile string, timeout time.Duration) (int, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		pid, err := readpid(pidfile)
+		if err == nil {
+			return pid, nil
+		}
+
+		if time.Now().After(deadline) {
+			return 0, err
+		}
+
+		// wait a short jittered interval in case the pidfile is being rewritten
+		time.Sleep(pidfileRetryInterval + rand.N(pidfileRetryInterval))
+	}
 }
 
-func NewProcessReloaderFromPidfile(pidfile string, signal syscall.Signal) (*ProcessReloader, error) {
+func readpid(pidfile string) (int, error) {
 	b, err := os.ReadFile(pidfile)
 	if err != nil {
-		return nil, fmt.Errorf("could not open pid file: %w", err)
+		return 0, fmt.Errorf("could not open pid file: %w", err)
 	}
 
 	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
 	if err != nil {
-		return nil, fmt.Errorf("invalid pid from pidfile: %w", err)
+		return 0, fmt.Errorf("invalid pid from pidfile: %w", err)
 	}
 
-	return NewProcessReloader(pid, signal)
-}
+	if pid <= 0 {
+		return 0, fmt.Errorf("invalid pid from pidfile: %d", pid)
+	}
 
-func NewProcessReloader(pid int, signal syscall.Signal) (*ProcessReloader, error) {
-	return &ProcessReloader{pid, signal}, nil
+	if !alive(pid) {
+		return 0, fmt.Errorf("process from pidfile is not running: %d", pid)
+	}
+
+	return pid, nil
 }
 
 func (r *ProcessReloader) Info() string {
+	if r.pgid != 0 {
+		return fmt.Sprintf("PGID = %d", r.pgid)
+	}
+
+	if r.name != "" {
+		return fmt.Sprintf("name = %s, PID = %v", r.name, r.pids)
+	}
+
 	p, err := os.FindProcess(r.pid)
 	if err != nil {
 		return "process not found"
 	}
 
-	if err := p.Signal(syscall.Signal(0)); err != nil {
-		return "process not found"
+	// EPERM means the process exists but we may not signal it
+	err = p.Signal(syscall.Signal(0))
+	switch {
+	case err == nil:
+		return fmt.Sprintf("PID = %d", p.Pid)
+	case errors.Is(err, syscall.EPERM):
+		return fmt.Sprintf("PID = %d (permission denied)", p.Pid)
 	}
 
-	return fmt.Sprint("PID = %d", p.Pid)
+	return "process not found"
 }
 
 func (r *ProcessReloader) Pid() int {
 	return r.pid
 }
 
-func (r *ProcessReloader) Reload() error {
-	p, err := os.FindProcess(r.pid)
+// target returns the pid to signal as per kill(2), which is negative for
+// a process group
+func (r *ProcessReloader) target() int {
+	if r.pgid != 0 {
+		return -r.pgid
+	}
+
+	return r.pid
+}
+
+func (r *ProcessReloader) Reload(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// signal the whole process group
+	if r.pgid != 0 {
+		return r.reloadgroup(ctx)
+	}
+
+	// look up pid again in case the process has restarted
+	if r.pidfile != "" {
+		pid, err := readpidfile(r.pidfile, r.timeout)
+		if err != nil {
+			return err
+		}
+
+		r.pid = pid
+	}
+
+	// look up processes by name again in case they have restarted
+	if r.name != "" {
+		pids, err := findpids(r.name, r.all)
+		if err != nil {
+			return err
+		}
+
+		r.pid = pids[0]
+		r.pids = pids
+
+		errs := make([]error, 0)
+		for _, pid := range pids {
+			if err := signalpid(pid, r.signal); err != nil {
+				errs = append(errs, fmt.Errorf("pid %d: %w", pid, err))
+			}
+		}
+
+		return errors.Join(errs...)
+	}
+
+	return signalpid(r.pid, r.signal)
+}
+
+func signalpid(pid int, signal syscall.Signal) error {
+	p, err := os.FindProcess(pid)
 	if err != nil {
 		return fmt.Errorf("could not find process: %w", err)
 	}
 
-	if err := p.Signal(r.signal); err != nil {
+	if err := p.Signal(signal); err != nil {
 		return fmt.Errorf("reload error: %w", err)
 	}
 
@@ -70,20 +321,103 @@ func (r *ProcessReloader) Reload() error {
 }
 
 type HTTPReloader struct {
-	url     string
-	method  string
-	payload []byte
+	url         string
+	method      string
+	payload     []byte
+	protocols   *http.Protocols
+	expect      []int
+	contentType string
+	timeout     time.Duration
+	headers     http.Header
+
+	client *http.Client
+}
+
+// HTTPReloaderOption configures optional behaviour of a HTTPReloader
+type HTTPReloaderOption func(*HTTPReloader)
+
+// WithHTTP1 forces the reload request to use HTTP/1.1
+func WithHTTP1() HTTPReloaderOption {
+	return func(r *HTTPReloader) {
+		r.protocols = new(http.Protocols)
+		r.protocols.SetHTTP1(true)
+	}
+}
+
+// WithHTTP2 forces the reload request to use HTTP/2, including over
+// unencrypted connections
+func WithHTTP2() HTTPReloaderOption {
+	return func(r *HTTPReloader) {
+		r.protocols = new(http.Protocols)
+		r.protocols.SetHTTP2(true)
+		r.protocols.SetUnencryptedHTTP2(true)
+	}
 }
 
-func NewHTTPReloader(url string, method string, payload []byte) (*HTTPReloader, error) {
-	return &HTTPReloader{url, method, payload}, nil
+// WithExpectStatus only treats the listed status codes as a successful
+// reload rather than any 2xx status
+func WithExpectStatus(codes ...int) HTTPReloaderOption {
+	return func(r *HTTPReloader) {
+		r.expect = codes
+	}
+}
+
+// WithContentType sets the Content-Type header of reload requests that
+// include a payload
+func WithContentType(contentType string) HTTPReloaderOption {
+	return func(r *HTTPReloader) {
+		r.contentType = contentType
+	}
+}
+
+// WithHTTPTimeout limits how long the whole reload request may take
+func WithHTTPTimeout(timeout time.Duration) HTTPReloaderOption {
+	return func(r *HTTPReloader) {
+		r.timeout = timeout
+	}
+}
+
+// WithHTTPHeaders adds headers, such as an Authorization header, to the
+// reload request
+func WithHTTPHeaders(headers http.Header) HTTPReloaderOption {
+	return func(r *HTTPReloader) {
+		r.headers = headers
+	}
+}
+
+func NewHTTPReloader(url string, method string, payload []byte, opts ...HTTPReloaderOption) (*HTTPReloader, error) {
+	// normalise and validate method
+	method = strings.ToUpper(strings.TrimSpace(method))
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+	default:
+		return nil, fmt.Errorf("invalid HTTP method: %q", method)
+	}
+
+	r := &HTTPReloader{url: url, method: method, payload: payload}
+	for _, o := range opts {
+		o(r)
+	}
+
+	// use a dedicated client so the timeout does not affect others
+	r.client = &http.Client{Timeout: r.timeout}
+
+	// use a dedicated transport when the protocol is forced
+	if r.protocols != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.Protocols = r.protocols
+		r.client.Transport = transport
+	}
+
+	return r, nil
 }
 
 func (r *HTTPReloader) Info() string {
 	return r.url
 }
 
-func (r *HTTPReloader) Reload() error {
+func (r *HTTPReloader) Reload(ctx context.Context) error {
 	var buf bytes.Buffer
 
 	if r.payload != nil {
@@ -92,51 +426,178 @@ func (r *HTTPReloader) Reload() error {
 	}
 
 	// set up request
-	req, err := http.NewRequest(r.method, r.url, &buf)
+	req, err := http.NewRequestWithContext(ctx, r.method, r.url, &buf)
 	if err != nil {
 		return fmt.Errorf("could not build request: %w", err)
 	}
+	for key, values := range r.headers {
+		for _, value := range values {
+			req.Header.Add(key, value)
+		}
+	}
+	if r.payload != nil && r.contentType != "" {
+		req.Header.Set("Content-Type", r.contentType)
+	}
 
 	// do request
-	res, err := http.DefaultClient.Do(req)
+	res, err := r.client.Do(req)
 	if err != nil {
+		var ne net.Error
+		if errors.As(err, &ne) && ne.Timeout() {
+			return fmt.Errorf("reload request timed out after %s: %w", r.timeout, err)
+		}
+
 		return fmt.Errorf("error during request: %w", err)
 	}
+	defer res.Body.Close()
 
 	// check response
-	if res.StatusCode != http.StatusOK {
+	if !r.success(res.StatusCode) {
 		return fmt.Errorf("bad response code: %d", res.StatusCode)
 	}
 
 	return nil
 }
 
-type UnixSocketReloader struct {
+// success reports if code indicates the reload was successful
+func (r *HTTPReloader) success(code int) bool {
+	if len(r.expect) > 0 {
+		return slices.Contains(r.expect, code)
+	}
+
+	return code >= 200 && code < 300
+}
+
+// SocketReloader sends a payload to a unix or TCP socket to reload
+type SocketReloader struct {
+	network string
 	socket  string
 	payload []byte
+	timeout time.Duration
+	ack     string
+}
+
+// UnixSocketReloader is the name SocketReloader had before TCP support
+type UnixSocketReloader = SocketReloader
+
+// SocketReloaderOption configures optional behaviour of a SocketReloader
+type SocketReloaderOption func(*SocketReloader)
+
+// UnixSocketReloaderOption is the name SocketReloaderOption had before
+// TCP support
+type UnixSocketReloaderOption = SocketReloaderOption
+
+// WithSocketTimeout limits how long connecting, writing the payload and
+// reading any acknowledgement may take
+func WithSocketTimeout(timeout time.Duration) SocketReloaderOption {
+	return func(r *SocketReloader) {
+		r.timeout = timeout
+	}
+}
+
+// WithSocketAck reads a response line after sending the payload and only
+// treats the reload as successful if the line starts with ack
+func WithSocketAck(ack string) SocketReloaderOption {
+	return func(r *SocketReloader) {
+		r.ack = ack
+	}
 }
 
-func NewUnixSocketReloader(socket string, payload []byte) (*UnixSocketReloader, error) {
+// NewUnixSocketReloader sends payload, followed by a newline, to the unix
+// socket at socket
+func NewUnixSocketReloader(socket string, payload []byte, opts ...SocketReloaderOption) (*SocketReloader, error) {
+	return newSocketReloader("unix", socket, payload, opts...), nil
+}
+
+// NewTCPReloader sends payload, followed by a newline, to the TCP address
+// addr given as host:port
+func NewTCPReloader(addr string, payload []byte, opts ...SocketReloaderOption) (*SocketReloader, error) {
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return nil, fmt.Errorf("invalid address: %w", err)
+	}
+
+	return newSocketReloader("tcp", addr, payload, opts...), nil
+}
+
+func newSocketReloader(network, socket string, payload []byte, opts ...SocketReloaderOption) *SocketReloader {
 	payload = append(payload, '\n')
-	return &UnixSocketReloader{socket, payload}, nil
+
+	r := &SocketReloader{network: network, socket: socket, payload: payload}
+	for _, o := range opts {
+		o(r)
+	}
+
+	return r
 }
 
-func (r *UnixSocketReloader) Info() string {
+func (r *SocketReloader) Info() string {
+	if r.network == "tcp" {
+		return "tcp://" + r.socket
+	}
+
 	return r.socket
 }
 
-func (r *UnixSocketReloader) Reload() error {
+func (r *SocketReloader) Reload(ctx context.Context) error {
+	if r.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
 	// connect to socket
-	conn, err := net.Dial("unix", r.socket)
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, r.network, r.socket)
 	if err != nil {
 		return fmt.Errorf("could not connect: %w", err)
 	}
 	defer conn.Close()
 
+	// do not wait forever on a stuck peer
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return fmt.Errorf("could not set deadline: %w", err)
+		}
+	}
+
+	// unblock any read or write if cancelled before the deadline
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Now())
+	})
+	defer stop()
+
 	// send payload
 	if _, err := conn.Write(r.payload); err != nil {
+		if errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) {
+			return fmt.Errorf("peer closed the connection before the payload was sent: %w", err)
+		}
+
 		return fmt.Errorf("error writing: %w", err)
 	}
 
+	// confirm the reload was accepted
+	if r.ack != "" {
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+			return fmt.Errorf("error reading acknowledgement: %w", err)
+		}
+
+		if !strings.HasPrefix(strings.TrimSpace(line), r.ack) {
+			return fmt.Errorf("unexpected acknowledgement: %q", strings.TrimSpace(line))
+		}
+
+		return nil
+	}
+
+	// signal we are done so the peer sees a clean close, then discard any
+	// response until it closes, giving up quietly at the deadline as the
+	// payload has been sent
+	if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+		cw.CloseWrite()
+	}
+	if _, ok := ctx.Deadline(); ok {
+		io.Copy(io.Discard, conn)
+	}
+
 	return nil
 }
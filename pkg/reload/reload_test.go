@@ -0,0 +1,579 @@
+package reload
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewProcessReloaderFromPidfile(t *testing.T) {
+	dir := t.TempDir()
+
+	ours := filepath.Join(dir, "ours.pid")
+	if err := os.WriteFile(ours, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	empty := filepath.Join(dir, "empty.pid")
+	if err := os.WriteFile(empty, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		pidfile string
+		want    int
+		wantErr bool
+	}{
+		{name: "running process", pidfile: ours, want: os.Getpid(), wantErr: false},
+		{name: "empty pidfile", pidfile: empty, wantErr: true},
+		{name: "missing pidfile", pidfile: filepath.Join(dir, "missing.pid"), wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NewProcessReloaderFromPidfile(tt.pidfile, syscall.SIGHUP, time.Millisecond*200)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, got.Pid(), tt.name+": tt.want == got.Pid()")
+	}
+}
+
+func TestNewProcessReloaderFromPidfile_retry(t *testing.T) {
+	pidfile := filepath.Join(t.TempDir(), "late.pid")
+
+	// pidfile is written after the first attempt to read it
+	go func() {
+		time.Sleep(time.Millisecond * 100)
+		os.WriteFile(pidfile, []byte(strconv.Itoa(os.Getpid())), 0644)
+	}()
+
+	got, err := NewProcessReloaderFromPidfile(pidfile, syscall.SIGHUP, time.Second)
+	assert.Nil(t, err, "err == nil")
+	if err == nil {
+		assert.Equal(t, os.Getpid(), got.Pid(), "pid read after retry")
+	}
+}
+
+func TestProcessReloader_Info(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process signals are not tested on windows")
+	}
+
+	// a process that has exited and been reaped
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		pid  int
+		want string
+		skip bool
+	}{
+		{name: "running process", pid: os.Getpid(), want: fmt.Sprintf("PID = %d", os.Getpid())},
+		{name: "exited process", pid: exited.Process.Pid, want: "process not found"},
+		{name: "permission denied", pid: 1, want: "PID = 1 (permission denied)", skip: os.Geteuid() == 0},
+	}
+	for _, tt := range tests {
+		if tt.skip {
+			continue
+		}
+
+		r, err := NewProcessReloader(tt.pid, syscall.SIGHUP)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		assert.Equal(t, tt.want, r.Info(), tt.name+": tt.want == r.Info()")
+	}
+}
+
+func TestNewProcessReloaderFromName(t *testing.T) {
+	procs := []process{
+		{pid: os.Getpid(), comm: "reload.test", cmdline: []string{"/tmp/reload.test"}},
+		{pid: 100, comm: "nginx", cmdline: []string{"nginx: master process /usr/sbin/nginx"}},
+		{pid: 101, comm: "nginx", cmdline: []string{"nginx: worker process"}},
+		{pid: 200, comm: "haproxy", cmdline: []string{"/usr/sbin/haproxy", "-f", "/etc/haproxy/haproxy.cfg"}},
+		{pid: 300, comm: "long-process-na", cmdline: []string{"/usr/bin/long-process-name"}},
+	}
+
+	listprocesses = func() ([]process, error) { return procs, nil }
+	t.Cleanup(func() { listprocesses = processes })
+
+	tests := []struct {
+		name    string
+		process string
+		all     bool
+		want    []int
+		wantErr bool
+	}{
+		{name: "single match", process: "haproxy", want: []int{200}},
+		{name: "match on cmdline", process: "long-process-name", want: []int{300}},
+		{name: "multiple matches", process: "nginx", wantErr: true},
+		{name: "multiple matches with all", process: "nginx", all: true, want: []int{100, 101}},
+		{name: "no match", process: "caddy", wantErr: true},
+		{name: "ourselves", process: "reload.test", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NewProcessReloaderFromName(tt.process, syscall.SIGHUP, tt.all)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, got.pids, tt.name+": tt.want == got.pids")
+		assert.Equal(t, tt.want[0], got.Pid(), tt.name+": tt.want[0] == got.Pid()")
+	}
+}
+
+func TestProcessReloader_Reload_name(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("process signals are not tested on windows")
+	}
+
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer cmd.Wait()
+
+	// the process restarts with a new pid after the reloader is created
+	pid := 999999
+	listprocesses = func() ([]process, error) {
+		return []process{{pid: pid, comm: "sleep", cmdline: []string{"sleep", "10"}}}, nil
+	}
+	t.Cleanup(func() { listprocesses = processes })
+
+	r, err := NewProcessReloaderFromName("sleep", syscall.SIGTERM, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pid = cmd.Process.Pid
+	assert.Nil(t, r.Reload(context.Background()), "err == nil")
+	assert.Equal(t, cmd.Process.Pid, r.Pid(), "pid looked up again on reload")
+}
+
+func TestNewHTTPReloader(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		want    string
+		wantErr bool
+	}{
+		{name: "post", method: "POST", want: "POST", wantErr: false},
+		{name: "mixed case", method: "POSt", want: "POST", wantErr: false},
+		{name: "whitespace", method: " get ", want: "GET", wantErr: false},
+		{name: "unknown", method: "RELOAD", wantErr: true},
+		{name: "empty", method: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := NewHTTPReloader("http://localhost/reload", tt.method, nil)
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+		assert.Equal(t, tt.want, got.method, tt.name+": tt.want == got.method")
+	}
+}
+
+func TestHTTPReloader_Reload_http2(t *testing.T) {
+	var proto string
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proto = r.Proto
+	}))
+	ts.Config.Protocols = new(http.Protocols)
+	ts.Config.Protocols.SetHTTP1(true)
+	ts.Config.Protocols.SetUnencryptedHTTP2(true)
+	ts.Start()
+	defer ts.Close()
+
+	r, err := NewHTTPReloader(ts.URL, http.MethodPost, nil, WithHTTP2())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Nil(t, r.Reload(context.Background()), "err == nil")
+	assert.Equal(t, "HTTP/2.0", proto, "request used HTTP/2")
+}
+
+func TestHTTPReloader_Reload_status(t *testing.T) {
+	var status int
+	var contentType string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		status  int
+		opts    []HTTPReloaderOption
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK, opts: nil, wantErr: false},
+		{name: "accepted", status: http.StatusAccepted, opts: nil, wantErr: false},
+		{name: "no content", status: http.StatusNoContent, opts: nil, wantErr: false},
+		{name: "not modified", status: http.StatusNotModified, opts: nil, wantErr: true},
+		{name: "server error", status: http.StatusInternalServerError, opts: nil, wantErr: true},
+		{name: "expected no content", status: http.StatusNoContent, opts: []HTTPReloaderOption{WithExpectStatus(http.StatusNoContent)}, wantErr: false},
+		{name: "unexpected ok", status: http.StatusOK, opts: []HTTPReloaderOption{WithExpectStatus(http.StatusNoContent, http.StatusAccepted)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		status = tt.status
+
+		r, err := NewHTTPReloader(ts.URL, http.MethodPost, []byte(`{}`), append(tt.opts, WithContentType("application/json"))...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Reload(context.Background())
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+		assert.Equal(t, "application/json", contentType, tt.name+": content type sent")
+	}
+}
+
+func TestHTTPReloader_Reload_timeout(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if r.URL.Query().Has("slow") {
+			time.Sleep(time.Millisecond * 500)
+		}
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name    string
+		url     string
+		headers http.Header
+		wantErr string
+	}{
+		{name: "success", url: ts.URL, headers: http.Header{"Authorization": {"Bearer secret"}}, wantErr: ""},
+		{name: "missing header", url: ts.URL, headers: nil, wantErr: "bad response code: 401"},
+		{name: "slow endpoint", url: ts.URL + "?slow", headers: http.Header{"Authorization": {"Bearer secret"}}, wantErr: "reload request timed out after 100ms"},
+	}
+	for _, tt := range tests {
+		r, err := NewHTTPReloader(tt.url, http.MethodPost, nil, WithHTTPTimeout(time.Millisecond*100), WithHTTPHeaders(tt.headers))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Reload(context.Background())
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.name+": error")
+			continue
+		}
+
+		assert.Nil(t, err, tt.name+": err == nil")
+	}
+}
+
+func TestUnixSocketReloader_Reload(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not tested on windows")
+	}
+
+	tests := []struct {
+		name    string
+		reply   string
+		opts    []UnixSocketReloaderOption
+		wantErr bool
+	}{
+		{name: "fire and forget", reply: "", opts: nil, wantErr: false},
+		{name: "ack", reply: "OK reloaded\n", opts: []UnixSocketReloaderOption{WithSocketAck("OK")}, wantErr: false},
+		{name: "wrong ack", reply: "ERR\n", opts: []UnixSocketReloaderOption{WithSocketAck("OK")}, wantErr: true},
+		{name: "stalled peer", reply: "", opts: []UnixSocketReloaderOption{WithSocketAck("OK"), WithSocketTimeout(time.Millisecond * 100)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		socket := filepath.Join(t.TempDir(), "reload.sock")
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			bufio.NewReader(conn).ReadString('\n')
+			if tt.reply != "" {
+				conn.Write([]byte(tt.reply))
+			} else {
+				time.Sleep(time.Millisecond * 500)
+			}
+		}()
+
+		r, err := NewUnixSocketReloader(socket, []byte("reload"), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Reload(context.Background())
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+
+		l.Close()
+	}
+}
+
+func TestTCPReloader_Reload(t *testing.T) {
+	tests := []struct {
+		name    string
+		reply   string
+		opts    []SocketReloaderOption
+		wantErr bool
+	}{
+		{name: "fire and forget", reply: "", opts: nil, wantErr: false},
+		{name: "ack", reply: "OK reloaded\n", opts: []SocketReloaderOption{WithSocketAck("OK")}, wantErr: false},
+		{name: "wrong ack", reply: "ERR\n", opts: []SocketReloaderOption{WithSocketAck("OK")}, wantErr: true},
+		{name: "stalled peer", reply: "", opts: []SocketReloaderOption{WithSocketAck("OK"), WithSocketTimeout(time.Millisecond * 100)}, wantErr: true},
+	}
+	for _, tt := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := make(chan string, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			line, _ := bufio.NewReader(conn).ReadString('\n')
+			got <- line
+			if tt.reply != "" {
+				conn.Write([]byte(tt.reply))
+			} else {
+				time.Sleep(time.Millisecond * 500)
+			}
+		}()
+
+		r, err := NewTCPReloader(l.Addr().String(), []byte("reload"), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = r.Reload(context.Background())
+		if tt.wantErr {
+			assert.NotNil(t, err, tt.name+": err != nil")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+			assert.Equal(t, "reload\n", <-got, tt.name+": payload")
+		}
+
+		l.Close()
+	}
+}
+
+func TestTCPReloader_Reload_openPeer(t *testing.T) {
+	tests := []struct {
+		name  string
+		reply string
+		opts  []SocketReloaderOption
+	}{
+		{name: "fire and forget", opts: []SocketReloaderOption{WithSocketTimeout(time.Millisecond * 200)}},
+		{name: "ack", reply: "OK\n", opts: []SocketReloaderOption{WithSocketAck("OK"), WithSocketTimeout(time.Second * 5)}},
+	}
+	for _, tt := range tests {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// a peer that keeps the connection open after replying
+		done := make(chan struct{})
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			bufio.NewReader(conn).ReadString('\n')
+			conn.Write([]byte(tt.reply))
+			<-done
+		}()
+
+		r, err := NewTCPReloader(l.Addr().String(), []byte("reload"), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		assert.Nil(t, r.Reload(context.Background()), tt.name+": err == nil")
+		assert.Less(t, time.Since(start), time.Second, tt.name+": returned without waiting for the peer to close")
+
+		close(done)
+		l.Close()
+	}
+}
+
+func TestTCPReloader_Reload_halfClose(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// a peer that reads until the connection is closed for writing
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			got <- nil
+			return
+		}
+		defer conn.Close()
+
+		b, _ := io.ReadAll(conn)
+		conn.Write([]byte("ignored\n"))
+		got <- b
+	}()
+
+	r, err := NewTCPReloader(l.Addr().String(), []byte("reload"), WithSocketTimeout(time.Second*5))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	assert.Nil(t, r.Reload(context.Background()), "err == nil")
+	assert.Less(t, time.Since(start), time.Second, "returned once the peer closed")
+	assert.Equal(t, "reload\n", string(<-got), "peer saw EOF after the payload")
+}
+
+func TestNewTCPReloader(t *testing.T) {
+	_, err := NewTCPReloader("localhost", []byte("reload"))
+	assert.NotNil(t, err, "missing port: err != nil")
+
+	r, err := NewTCPReloader("localhost:9000", []byte("reload"))
+	assert.Nil(t, err, "host:port: err == nil")
+	assert.Equal(t, "tcp://localhost:9000", r.Info())
+}
+
+func TestUnixSocketReloader_Reload_context(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not tested on windows")
+	}
+
+	socket := filepath.Join(t.TempDir(), "reload.sock")
+	l, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	// a peer that never acknowledges the reload
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		time.Sleep(time.Second * 2)
+	}()
+
+	r, err := NewUnixSocketReloader(socket, []byte("reload"), WithSocketAck("OK"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	assert.NotNil(t, r.Reload(ctx), "err != nil")
+	assert.Less(t, time.Since(start), time.Second, "reload gave up when the context expired")
+}
+
+func TestUnixSocketReloader_Reload_peer(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix sockets are not tested on windows")
+	}
+
+	// large enough to fill the socket buffer of a peer that does not read
+	payload := bytes.Repeat([]byte("x"), 4<<20)
+
+	tests := []struct {
+		name    string
+		peer    func(net.Conn)
+		wantErr string
+	}{
+		{name: "closed early", peer: func(conn net.Conn) { conn.Close() }, wantErr: "peer closed the connection"},
+		{name: "stalled", peer: func(conn net.Conn) { time.Sleep(time.Second * 2) }, wantErr: "i/o timeout"},
+		{name: "reads and closes", peer: func(conn net.Conn) { io.Copy(io.Discard, conn) }, wantErr: ""},
+	}
+	for _, tt := range tests {
+		socket := filepath.Join(t.TempDir(), "reload.sock")
+		l, err := net.Listen("unix", socket)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+
+			tt.peer(conn)
+		}()
+
+		r, err := NewUnixSocketReloader(socket, payload, WithSocketTimeout(time.Millisecond*200))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		start := time.Now()
+		err = r.Reload(context.Background())
+		if tt.wantErr != "" {
+			assert.ErrorContains(t, err, tt.wantErr, tt.name+": error")
+		} else {
+			assert.Nil(t, err, tt.name+": err == nil")
+		}
+		assert.Less(t, time.Since(start), time.Second, tt.name+": reload returned before the peer gave up")
+
+		l.Close()
+	}
+}
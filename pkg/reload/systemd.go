@@ -0,0 +1,11 @@
+package reload
+
+// SystemdReloader notifies systemd of a reload over $NOTIFY_SOCKET as per
+// sd_notify, for services using Type=notify-reload or Type=notify
+type SystemdReloader struct {
+	socket string
+}
+
+func (r *SystemdReloader) Info() string {
+	return r.socket
+}
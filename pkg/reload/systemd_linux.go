@@ -0,0 +1,58 @@
+package reload
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// NewSystemdReloader sends the reload notification to the socket set in
+// the NOTIFY_SOCKET environment variable
+func NewSystemdReloader() (*SystemdReloader, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil, fmt.Errorf("NOTIFY_SOCKET is not set")
+	}
+
+	return &SystemdReloader{socket: socket}, nil
+}
+
+func (r *SystemdReloader) Reload(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// systemd requires the time the reload started with RELOADING=1
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return fmt.Errorf("could not read monotonic clock: %w", err)
+	}
+	usec := ts.Nano() / int64(time.Microsecond)
+
+	// a leading "@" is an abstract socket, which is handled by net
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: r.socket, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("could not connect to notify socket: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	for _, msg := range []string{
+		"RELOADING=1\nMONOTONIC_USEC=" + strconv.FormatInt(usec, 10) + "\n",
+		"READY=1\n",
+	} {
+		if _, err := conn.Write([]byte(msg)); err != nil {
+			return fmt.Errorf("could not send notification: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package reload
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSystemdReloader_Reload(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	t.Setenv("NOTIFY_SOCKET", socket)
+
+	r, err := NewSystemdReloader()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, socket, r.Info(), "socket == r.Info()")
+
+	assert.Nil(t, r.Reload(context.Background()), "err == nil")
+
+	// read both notifications
+	conn.SetReadDeadline(time.Now().Add(time.Second * 5))
+	got := make([]string, 0)
+	buf := make([]byte, 1024)
+	for range 2 {
+		n, err := conn.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(buf[:n]))
+	}
+
+	if assert.Len(t, got, 2, "two notifications") {
+		assert.True(t, strings.HasPrefix(got[0], "RELOADING=1\nMONOTONIC_USEC="), "reloading sent first")
+		assert.Equal(t, "READY=1\n", got[1], "ready sent last")
+	}
+}
+
+func TestNewSystemdReloader(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+
+	_, err := NewSystemdReloader()
+	assert.NotNil(t, err, "NOTIFY_SOCKET not set: err != nil")
+}
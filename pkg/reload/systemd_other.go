@@ -0,0 +1,19 @@
+//go:build !linux
+
+package reload
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+)
+
+// NewSystemdReloader is only supported on linux
+func NewSystemdReloader() (*SystemdReloader, error) {
+	return nil, fmt.Errorf("systemd based reloads are not supported on %s", runtime.GOOS)
+}
+
+func (r *SystemdReloader) Reload(ctx context.Context) error {
+	return errors.ErrUnsupported
+}